@@ -3,12 +3,21 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"time"
 
+	"logana/internal/analyzer"
+	"logana/internal/bookmarks"
+	"logana/internal/exporter"
+	"logana/internal/formats"
 	"logana/internal/gifer"
+	"logana/internal/highlight"
+	"logana/internal/history"
+	"logana/internal/logdiff"
+	"logana/internal/pipeline"
 	"logana/internal/replacer"
 	"logana/internal/scanner"
 
@@ -18,12 +27,20 @@ import (
 
 // App struct
 type App struct {
-	ctx        context.Context
-	scanner    *scanner.ParallelScanner
-	replacer   *replacer.Replacer
-	gifer      *gifer.Gifer
-	cancelFunc context.CancelFunc
-	isVisible  bool
+	ctx         context.Context
+	scanner     *scanner.ParallelScanner
+	replacer    *replacer.Replacer
+	gifer       *gifer.Gifer
+	history     *history.History
+	bookmarks   *bookmarks.Store
+	pipelines   *pipeline.Store
+	highlights  *highlight.Store
+	cancelFunc  context.CancelFunc
+	exportStop  context.CancelFunc
+	diffStop    context.CancelFunc
+	analyzeStop context.CancelFunc
+	applyStop   context.CancelFunc
+	isVisible   bool
 }
 
 // NewApp creates a new App application struct
@@ -32,9 +49,13 @@ func NewApp() *App {
 	appConfigDir := filepath.Join(userHome, ".logana")
 
 	return &App{
-		scanner:  scanner.NewParallelScanner(runtime.NumCPU()),
-		replacer: replacer.NewReplacer(appConfigDir),
-		gifer:    gifer.NewGifer(),
+		scanner:    scanner.NewParallelScanner(runtime.NumCPU()),
+		replacer:   replacer.NewReplacer(appConfigDir),
+		gifer:      gifer.NewGifer(),
+		history:    history.NewHistory(appConfigDir),
+		bookmarks:  bookmarks.NewStore(appConfigDir),
+		pipelines:  pipeline.NewStore(appConfigDir),
+		highlights: highlight.NewStore(appConfigDir),
 	}
 }
 
@@ -95,6 +116,13 @@ func (a *App) SelectFile() (string, error) {
 
 // Search starts a log search operation
 func (a *App) Search(opts scanner.SearchOptions) error {
+	_, err := a.runSearch(opts)
+	return err
+}
+
+// runSearch streams a search to the frontend, records it in the history
+// and returns the number of results delivered
+func (a *App) runSearch(opts scanner.SearchOptions) (int64, error) {
 	a.CancelSearch() // Cancel any existing search
 
 	startTime := time.Now()
@@ -122,7 +150,10 @@ func (a *App) Search(opts scanner.SearchOptions) error {
 	}()
 
 	// Handle results
+	var resultCount int64
+	resultsDone := make(chan struct{})
 	go func() {
+		defer close(resultsDone)
 		batchSize := 50
 		var batch []scanner.Match
 		for {
@@ -131,6 +162,9 @@ func (a *App) Search(opts scanner.SearchOptions) error {
 				if len(batch) > 0 {
 					wailsruntime.EventsEmit(a.ctx, "search_results", batch)
 				}
+				// Keep draining so the scanner never blocks on a full channel
+				for range resultsChan {
+				}
 				return
 			case match, ok := <-resultsChan:
 				if !ok {
@@ -139,6 +173,7 @@ func (a *App) Search(opts scanner.SearchOptions) error {
 					}
 					return
 				}
+				resultCount++
 				batch = append(batch, match)
 				if len(batch) >= batchSize {
 					wailsruntime.EventsEmit(a.ctx, "search_results", batch)
@@ -148,10 +183,17 @@ func (a *App) Search(opts scanner.SearchOptions) error {
 		}
 	}()
 
-	err := a.scanner.Scan(searchCtx, opts, progressChan, resultsChan)
+	summary, err := a.scanner.Scan(searchCtx, opts, progressChan, resultsChan)
 
 	close(progressChan)
 	close(resultsChan)
+	<-resultsDone
+
+	if err == nil {
+		if herr := a.history.Record(opts, resultCount); herr != nil {
+			wailsruntime.LogWarningf(a.ctx, "failed to record search history: %v", herr)
+		}
+	}
 
 	elapsed := time.Since(startTime).Seconds()
 
@@ -172,12 +214,298 @@ func (a *App) Search(opts scanner.SearchOptions) error {
 			}
 			return ""
 		}(),
-		"elapsed": elapsed,
+		"elapsed":   elapsed,
+		"files":     summary.Files,
+		"encodings": summary.Encodings,
+		"formats":   summary.Formats,
+		"levels":    summary.Levels,
+		"estimate":  summary.Estimate,
+		"truncated": summary.TruncatedLines,
+	})
+
+	return resultCount, err
+}
+
+// ListLogFormats returns the log formats that can be set in SearchOptions.Format
+func (a *App) ListLogFormats() []string {
+	return append([]string{formats.FormatAuto}, formats.Names()...)
+}
+
+// ExportResults runs a search straight into a file chosen with a save dialog.
+// format is "txt", "jsonl" or "csv". Results are written in file order and are
+// not limited by MaxResults. Returns the chosen path, or "" if the dialog was cancelled.
+func (a *App) ExportResults(opts scanner.SearchOptions, format string) (string, error) {
+	filters := map[string]wailsruntime.FileFilter{
+		exporter.FormatText:  {DisplayName: "Text Files (*.txt)", Pattern: "*.txt"},
+		exporter.FormatJSONL: {DisplayName: "JSON Lines (*.jsonl)", Pattern: "*.jsonl"},
+		exporter.FormatCSV:   {DisplayName: "CSV Files (*.csv)", Pattern: "*.csv"},
+	}
+	filter, ok := filters[format]
+	if !ok {
+		return "", fmt.Errorf("unsupported export format '%s'", format)
+	}
+
+	outputPath, err := wailsruntime.SaveFileDialog(a.ctx, wailsruntime.SaveDialogOptions{
+		Title:           "Export Results",
+		DefaultFilename: "results." + format,
+		Filters:         []wailsruntime.FileFilter{filter},
+	})
+	if err != nil || outputPath == "" {
+		return "", err
+	}
+
+	fields, err := scanner.FieldNames(opts)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	writer, err := exporter.NewWriter(out, format, fields, opts.Context > 0)
+	if err != nil {
+		return "", err
+	}
+
+	opts.MaxResults = -1
+	opts.Ordered = true
+	opts.CountOnly = false
+	opts.FilesWithMatches = false
+
+	startTime := time.Now()
+	progressChan := make(chan float64, 10)
+	resultsChan := make(chan scanner.Match, 100)
+
+	if a.exportStop != nil {
+		a.exportStop()
+	}
+	exportCtx, cancel := context.WithCancel(a.ctx)
+	a.exportStop = cancel
+	defer cancel()
+
+	go func() {
+		for p := range progressChan {
+			wailsruntime.EventsEmit(a.ctx, "export_progress", p)
+		}
+	}()
+
+	// Stop scanning on the first write error but keep draining the channel
+	var writeErr error
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		for match := range resultsChan {
+			if writeErr != nil {
+				continue
+			}
+			if writeErr = writer.Write(match); writeErr != nil {
+				cancel()
+			}
+		}
+	}()
+
+	_, err = a.scanner.Scan(exportCtx, opts, progressChan, resultsChan)
+	close(progressChan)
+	close(resultsChan)
+	<-writeDone
+
+	if writeErr != nil {
+		err = writeErr
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		wailsruntime.EventsEmit(a.ctx, "export_error", err.Error())
+		return "", err
+	}
+
+	wailsruntime.EventsEmit(a.ctx, "export_complete", map[string]interface{}{
+		"path":    outputPath,
+		"count":   writer.Count(),
+		"elapsed": time.Since(startTime).Seconds(),
+	})
+	return outputPath, nil
+}
+
+// CancelExport stops a running export
+func (a *App) CancelExport() {
+	if a.exportStop != nil {
+		a.exportStop()
+		a.exportStop = nil
+	}
+}
+
+// DiffLogs compares two log files after masking volatile fields and streams
+// diff hunks to the frontend
+func (a *App) DiffLogs(opts logdiff.Options) error {
+	a.CancelDiff()
+
+	startTime := time.Now()
+	progressChan := make(chan float64, 10)
+	hunksChan := make(chan logdiff.Hunk, 100)
+
+	diffCtx, cancel := context.WithCancel(a.ctx)
+	a.diffStop = cancel
+	defer cancel()
+
+	go func() {
+		for p := range progressChan {
+			wailsruntime.EventsEmit(a.ctx, "diff_progress", p)
+		}
+	}()
+
+	hunksDone := make(chan struct{})
+	go func() {
+		defer close(hunksDone)
+		batchSize := 20
+		var batch []logdiff.Hunk
+		for hunk := range hunksChan {
+			batch = append(batch, hunk)
+			if len(batch) >= batchSize {
+				wailsruntime.EventsEmit(a.ctx, "diff_hunks", batch)
+				batch = nil
+			}
+		}
+		if len(batch) > 0 {
+			wailsruntime.EventsEmit(a.ctx, "diff_hunks", batch)
+		}
+	}()
+
+	summary, err := logdiff.Diff(diffCtx, opts, progressChan, hunksChan)
+	close(progressChan)
+	close(hunksChan)
+	<-hunksDone
+
+	status := "complete"
+	if err != nil {
+		if err == context.Canceled {
+			status = "cancelled"
+		} else {
+			status = "error"
+		}
+	}
+
+	wailsruntime.EventsEmit(a.ctx, "diff_complete", map[string]interface{}{
+		"status": status,
+		"error": func() string {
+			if err != nil {
+				return err.Error()
+			}
+			return ""
+		}(),
+		"elapsed": time.Since(startTime).Seconds(),
+		"summary": summary,
 	})
 
 	return err
 }
 
+// CancelDiff stops a running log diff
+func (a *App) CancelDiff() {
+	if a.diffStop != nil {
+		a.diffStop()
+		a.diffStop = nil
+	}
+}
+
+// AnalyzeTop reports the most frequent IPs, requests, status codes and other
+// tokens in the lines matching opts.Search. Progress is streamed as analyze_progress events.
+func (a *App) AnalyzeTop(opts analyzer.Options) (analyzer.Report, error) {
+	a.CancelAnalyze()
+
+	startTime := time.Now()
+	progressChan := make(chan float64, 10)
+
+	analyzeCtx, cancel := context.WithCancel(a.ctx)
+	a.analyzeStop = cancel
+	defer cancel()
+
+	go func() {
+		for p := range progressChan {
+			wailsruntime.EventsEmit(a.ctx, "analyze_progress", p)
+		}
+	}()
+
+	report, err := analyzer.Analyze(analyzeCtx, a.scanner, opts, progressChan)
+	close(progressChan)
+
+	status := "complete"
+	if err != nil {
+		if err == context.Canceled {
+			status = "cancelled"
+		} else {
+			status = "error"
+		}
+	}
+
+	wailsruntime.EventsEmit(a.ctx, "analyze_complete", map[string]interface{}{
+		"status": status,
+		"error": func() string {
+			if err != nil {
+				return err.Error()
+			}
+			return ""
+		}(),
+		"elapsed": time.Since(startTime).Seconds(),
+	})
+
+	return report, err
+}
+
+// CancelAnalyze stops a running top-N analysis
+func (a *App) CancelAnalyze() {
+	if a.analyzeStop != nil {
+		a.analyzeStop()
+		a.analyzeStop = nil
+	}
+}
+
+// ListExtractors returns the names of the extractors available to AnalyzeTop
+func (a *App) ListExtractors() []string {
+	return analyzer.Extractors()
+}
+
+// ListSearchHistory returns recent searches, newest first
+func (a *App) ListSearchHistory() ([]history.Entry, error) {
+	return a.history.Recent()
+}
+
+// ListSavedSearches returns named saved searches
+func (a *App) ListSavedSearches() ([]history.Entry, error) {
+	return a.history.Saved()
+}
+
+// SaveSearch stores the options under a name, replacing a saved search with the same name
+func (a *App) SaveSearch(name string, opts scanner.SearchOptions, resultCount int64) (history.Entry, error) {
+	return a.history.Save(name, opts, resultCount)
+}
+
+// DeleteSearch removes a recent or saved search
+func (a *App) DeleteSearch(id string) error {
+	return a.history.Delete(id)
+}
+
+// RerunSearch runs a recent or saved search again with its stored options
+func (a *App) RerunSearch(id string) error {
+	entry, err := a.history.Get(id)
+	if err != nil {
+		return err
+	}
+
+	count, err := a.runSearch(entry.Options)
+	if err != nil {
+		return err
+	}
+	if entry.Name != "" {
+		return a.history.Touch(entry.ID, count)
+	}
+	return nil
+}
+
 // GetFileInfo returns basic information about a file
 func (a *App) GetFileInfo(filePath string) (map[string]interface{}, error) {
 	file, err := os.Stat(filePath)
@@ -190,19 +518,232 @@ func (a *App) GetFileInfo(filePath string) (map[string]interface{}, error) {
 	}, nil
 }
 
+// AddBookmark marks a line of the log file at b.File.Path
+func (a *App) AddBookmark(b bookmarks.Bookmark) (bookmarks.Bookmark, error) {
+	return a.bookmarks.Add(b)
+}
+
+// UpdateBookmark changes the note and color of a bookmark
+func (a *App) UpdateBookmark(id, note, color string) (bookmarks.Bookmark, error) {
+	return a.bookmarks.Update(id, note, color)
+}
+
+// DeleteBookmark removes a bookmark
+func (a *App) DeleteBookmark(id string) error {
+	return a.bookmarks.Delete(id)
+}
+
+// ListBookmarks returns the bookmarks of a log file ordered by offset
+func (a *App) ListBookmarks(filePath string) ([]bookmarks.Bookmark, error) {
+	return a.bookmarks.List(filePath)
+}
+
+// ExportTimeline writes the bookmarks of a log file as a Markdown incident timeline
+func (a *App) ExportTimeline(filePath string) (string, error) {
+	timeline, err := a.bookmarks.Timeline(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	selection, err := wailsruntime.SaveFileDialog(a.ctx, wailsruntime.SaveDialogOptions{
+		Title:           "Export Timeline",
+		DefaultFilename: filepath.Base(filePath) + ".timeline.md",
+		Filters: []wailsruntime.FileFilter{
+			{DisplayName: "Markdown Files (*.md)", Pattern: "*.md"},
+		},
+	})
+	if err != nil || selection == "" {
+		return "", err
+	}
+
+	return selection, os.WriteFile(selection, []byte(timeline), 0o644)
+}
+
 // ReplaceText performs multiple regex replacements
 func (a *App) ReplaceText(text string, rules []replacer.Rule) (string, error) {
 	return a.replacer.Replace(text, rules)
 }
 
-// SaveRuleSets saves rule sets to disk
-func (a *App) SaveRuleSets(ruleSets []replacer.RuleSet) error {
+// TraceReplace applies rules like ReplaceText and returns the text after each
+// rule with the matches it replaced, for a step-by-step view of a rule set
+func (a *App) TraceReplace(text string, rules []replacer.Rule) (replacer.Trace, error) {
+	return a.replacer.ReplaceTrace(text, rules)
+}
+
+// ValidateRules reports every active rule whose pattern does not compile,
+// with its index and the position of the error in the pattern
+func (a *App) ValidateRules(rules []replacer.Rule) []*replacer.CompileError {
+	return replacer.Validate(rules)
+}
+
+// SaveRuleSets saves rule sets to disk and runs their tests. The reports of
+// failing sets are returned so the UI can warn about them.
+func (a *App) SaveRuleSets(ruleSets []replacer.RuleSet) ([]replacer.TestReport, error) {
 	return a.replacer.SaveRuleSets(ruleSets)
 }
 
-// LoadRuleSets loads rule sets from disk
+// LoadRuleSets loads rule sets from disk. If the rules file is corrupt, the
+// newest valid backup is loaded and a rules_recovered event explains why.
 func (a *App) LoadRuleSets() ([]replacer.RuleSet, error) {
-	return a.replacer.LoadRuleSets()
+	ruleSets, warning, err := a.replacer.LoadRuleSets()
+	if warning != "" {
+		wailsruntime.EventsEmit(a.ctx, "rules_recovered", warning)
+	}
+	return ruleSets, err
+}
+
+// ListRuleVersions returns the earlier versions of the rule sets that can be restored
+func (a *App) ListRuleVersions() ([]replacer.RuleVersion, error) {
+	return a.replacer.ListRuleVersions()
+}
+
+// RestoreRuleVersion makes an earlier version the current rule sets and returns them
+func (a *App) RestoreRuleVersion(id string) ([]replacer.RuleSet, error) {
+	return a.replacer.RestoreRuleVersion(id)
+}
+
+// TestRuleSet runs the test cases of a rule set
+func (a *App) TestRuleSet(ruleSet replacer.RuleSet) replacer.TestReport {
+	return a.replacer.TestRuleSet(ruleSet)
+}
+
+// ApplyRulesToFiles runs a rule set over files and directories, in place or
+// into opts.OutputDir. Progress is streamed as apply_progress events.
+func (a *App) ApplyRulesToFiles(opts replacer.FileOptions) (replacer.FileSummary, error) {
+	a.CancelApply()
+
+	startTime := time.Now()
+	progressChan := make(chan float64, 10)
+
+	applyCtx, cancel := context.WithCancel(a.ctx)
+	a.applyStop = cancel
+	defer cancel()
+
+	go func() {
+		for p := range progressChan {
+			wailsruntime.EventsEmit(a.ctx, "apply_progress", p)
+		}
+	}()
+
+	summary, err := a.replacer.ApplyToFiles(applyCtx, opts, progressChan)
+	close(progressChan)
+
+	status := "complete"
+	if err != nil {
+		if err == context.Canceled {
+			status = "cancelled"
+		} else {
+			status = "error"
+		}
+	}
+
+	wailsruntime.EventsEmit(a.ctx, "apply_complete", map[string]interface{}{
+		"status": status,
+		"error": func() string {
+			if err != nil {
+				return err.Error()
+			}
+			return ""
+		}(),
+		"elapsed": time.Since(startTime).Seconds(),
+		"summary": summary,
+	})
+
+	return summary, err
+}
+
+// CancelApply stops applying rules to files
+func (a *App) CancelApply() {
+	if a.applyStop != nil {
+		a.applyStop()
+		a.applyStop = nil
+	}
+}
+
+// SelectDirectory opens a dialog to pick a directory
+func (a *App) SelectDirectory(title string) (string, error) {
+	return wailsruntime.OpenDirectoryDialog(a.ctx, wailsruntime.OpenDialogOptions{
+		Title: title,
+	})
+}
+
+// ListBuiltinRuleSets returns the rule sets shipped with the app, such as the PII packs
+func (a *App) ListBuiltinRuleSets() []replacer.RuleSet {
+	return replacer.BuiltinRuleSets()
+}
+
+// ExportRuleSet returns a rule set in the shareable export format, ready to paste into chat
+func (a *App) ExportRuleSet(ruleSet replacer.RuleSet, meta replacer.ExportMeta) (string, error) {
+	data, err := replacer.ExportRuleSets([]replacer.RuleSet{ruleSet}, meta)
+	return string(data), err
+}
+
+// SaveRuleSetExport writes a rule set in the export format to a file chosen by the user
+func (a *App) SaveRuleSetExport(ruleSet replacer.RuleSet, meta replacer.ExportMeta) (string, error) {
+	data, err := replacer.ExportRuleSets([]replacer.RuleSet{ruleSet}, meta)
+	if err != nil {
+		return "", err
+	}
+
+	selection, err := wailsruntime.SaveFileDialog(a.ctx, wailsruntime.SaveDialogOptions{
+		Title:           "Export Rule Set",
+		DefaultFilename: ruleSet.Name + ".rules.json",
+		Filters: []wailsruntime.FileFilter{
+			{DisplayName: "Rule Set Files (*.json)", Pattern: "*.json"},
+		},
+	})
+	if err != nil || selection == "" {
+		return "", err
+	}
+
+	return selection, os.WriteFile(selection, data, 0o644)
+}
+
+// OpenRuleSetFile lets the user pick an exported rule set file and returns its
+// contents for ImportRuleSets, or "" if the dialog was cancelled
+func (a *App) OpenRuleSetFile() (string, error) {
+	selection, err := wailsruntime.OpenFileDialog(a.ctx, wailsruntime.OpenDialogOptions{
+		Title: "Import Rule Sets",
+		Filters: []wailsruntime.FileFilter{
+			{DisplayName: "Rule Set Files (*.json)", Pattern: "*.json"},
+			{DisplayName: "All Files (*.*)", Pattern: "*.*"},
+		},
+	})
+	if err != nil || selection == "" {
+		return "", err
+	}
+
+	data, err := os.ReadFile(selection)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ImportRuleSets validates shared rule sets and saves them next to the
+// existing ones, resolving name conflicts with opts.Strategy
+func (a *App) ImportRuleSets(opts replacer.ImportOptions) (replacer.ImportResult, error) {
+	return a.replacer.ImportRuleSets(opts)
+}
+
+// SavePipelines saves filter pipelines to disk
+func (a *App) SavePipelines(pipelines []pipeline.Pipeline) error {
+	return a.pipelines.SavePipelines(pipelines)
+}
+
+// LoadPipelines loads filter pipelines from disk
+func (a *App) LoadPipelines() ([]pipeline.Pipeline, error) {
+	return a.pipelines.LoadPipelines()
+}
+
+// SaveHighlightProfiles saves highlight profiles to disk
+func (a *App) SaveHighlightProfiles(profiles []highlight.Profile) error {
+	return a.highlights.SaveProfiles(profiles)
+}
+
+// LoadHighlightProfiles loads highlight profiles from disk
+func (a *App) LoadHighlightProfiles() ([]highlight.Profile, error) {
+	return a.highlights.LoadProfiles()
 }
 
 // ConvertVideoToGif converts video to gif with progress updates
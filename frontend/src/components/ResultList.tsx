@@ -3,58 +3,35 @@ import { Match } from '../types';
 
 interface Props {
     results: Match[];
-    searchParams: {
-        query: string;
-        isRegex: boolean;
-        ignoreCase: boolean;
-        invert: boolean;
-    };
 }
 
-const ResultList: React.FC<Props> = ({ results, searchParams }) => {
-    const highlightText = (text: string) => {
-        if (!searchParams.query || searchParams.invert) return text;
-
-        try {
-            const queries = searchParams.query.trim().split(/\s+/);
-            if (queries.length === 0) return text;
-
-            // Sort queries by length descending to avoid partial matches on shorter words first
-            const sortedQueries = [...queries].sort((a, b) => b.length - a.length);
-            
-            let patterns: string[];
-            if (searchParams.isRegex) {
-                patterns = sortedQueries.map(q => `(${q})`);
-            } else {
-                patterns = sortedQueries.map(q => `(${q.replace(/[.*+?^${}()|[\]\\]/g, '\\$&')})`);
+const ResultList: React.FC<Props> = ({ results }) => {
+    // Spans come from the Go scanner, so highlighting follows RE2 semantics exactly.
+    // Rune offsets are used because JS strings index UTF-16 code units, not bytes.
+    const highlightText = (match: Match) => {
+        if (!match.spans || match.spans.length === 0) return match.content;
+
+        const chars = Array.from(match.content);
+        const sorted = [...match.spans].sort((a, b) => a.runeStart - b.runeStart || b.runeEnd - a.runeEnd);
+        const parts: React.ReactNode[] = [];
+        let pos = 0;
+
+        sorted.forEach((span, i) => {
+            // Overlapping spans from different terms are merged into the earlier one
+            if (span.runeEnd <= pos) return;
+            const start = Math.max(span.runeStart, pos);
+            if (start > pos) {
+                parts.push(chars.slice(pos, start).join(''));
             }
-
-            const masterRegex = new RegExp(patterns.join('|'), searchParams.ignoreCase ? 'gi' : 'g');
-
-            const parts = text.split(masterRegex);
-            // Master regex with capture groups will include matches in the split parts
-            return parts.map((part, i) => {
-                if (!part) return null;
-                
-                // Check if this part matches any of our query terms
-                const isMatch = queries.some(q => {
-                    const testRegex = new RegExp(
-                        searchParams.isRegex ? q : q.replace(/[.*+?^${}()|[\]\\]/g, '\\$&'),
-                        searchParams.ignoreCase ? 'i' : ''
-                    );
-                    return testRegex.test(part);
-                });
-
-                return isMatch ? (
-                    <mark key={i} className="bg-yellow-500 text-black rounded-sm px-0.5">{part}</mark>
-                ) : (
-                    part
-                );
-            });
-        } catch (e) {
-            console.error("Highlight error:", e);
-            return text;
+            parts.push(
+                <mark key={i} className="bg-yellow-500 text-black rounded-sm px-0.5">{chars.slice(start, span.runeEnd).join('')}</mark>
+            );
+            pos = span.runeEnd;
+        });
+        if (pos < chars.length) {
+            parts.push(chars.slice(pos).join(''));
         }
+        return parts;
     };
 
     return (
@@ -71,7 +48,7 @@ const ResultList: React.FC<Props> = ({ results, searchParams }) => {
                                 <span>Offset: {match.offset}</span>
                                 <span className="opacity-0 group-hover:opacity-100 transition-opacity">Row {i + 1}</span>
                             </div>
-                            <div className="break-all whitespace-pre-wrap">{highlightText(match.content)}</div>
+                            <div className="break-all whitespace-pre-wrap">{highlightText(match)}</div>
                         </div>
                     ))}
                 </div>
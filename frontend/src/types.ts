@@ -1,7 +1,34 @@
+export interface Span {
+    term: number;
+    start: number;
+    end: number;
+    runeStart: number;
+    runeEnd: number;
+}
+
+export interface ColorSpan {
+    rule: number;
+    color: string;
+    start: number;
+    end: number;
+    runeStart: number;
+    runeEnd: number;
+}
+
 export interface Match {
+    file?: string;
     line: number;
     content: string;
     offset: number;
+    contextBefore?: number;
+    spans?: Span[];
+    colors?: ColorSpan[];
+    fields?: Record<string, string>;
+    truncated?: boolean;
+    binary?: boolean;
+    count?: number;
+    lastOffset?: number;
+    lastLine?: number;
 }
 
 export interface SearchOptions {
@@ -13,4 +40,279 @@ export interface SearchOptions {
     Logic: string;
     Context: number;
     MaxResults: number;
+    Files?: string[];
+    CountOnly?: boolean;
+    FilesWithMatches?: boolean;
+    Encoding?: string;
+    BinaryAsText?: boolean;
+    Ordered?: boolean;
+    Stages?: PipelineStage[];
+    Highlight?: HighlightProfile;
+    Dedupe?: '' | 'exact' | 'masked';
+    Sample?: '' | 'reservoir' | 'stratified';
+    SampleSize?: number;
+    Format?: string;
+    MinLevel?: LogLevel;
+    Levels?: (LogLevel | 'unknown')[];
+}
+
+export type LogLevel = 'trace' | 'debug' | 'info' | 'warn' | 'error' | 'fatal';
+
+export interface Estimate {
+    method: 'reservoir' | 'stratified';
+    total: number;
+    low: number;
+    high: number;
+    exact: boolean;
+    sampledBytes: number;
+    totalBytes: number;
+}
+
+export interface FileCount {
+    file: string;
+    count: number;
+    matched: boolean;
+    binary?: boolean;
+}
+
+export interface HistoryEntry {
+    id: string;
+    name?: string;
+    options: SearchOptions;
+    filePath: string;
+    timestamp: string;
+    resultCount: number;
+}
+
+export interface FileIdentity {
+    path: string;
+    size: number;
+    modTime: string;
+    headHash: string;
+}
+
+export interface Bookmark {
+    id: string;
+    file: FileIdentity;
+    offset: number;
+    line?: number;
+    snippet: string;
+    note: string;
+    color: string;
+    createdAt: string;
+    updatedAt: string;
+}
+
+export interface DiffLine {
+    op: ' ' | '-' | '+';
+    text: string;
+    leftLine?: number;
+    rightLine?: number;
+}
+
+export interface DiffHunk {
+    leftStart: number;
+    leftCount: number;
+    rightStart: number;
+    rightCount: number;
+    lines: DiffLine[];
+    continued?: boolean;
+}
+
+export interface PipelineStage {
+    type: 'include' | 'exclude' | 'transform' | 'extract';
+    query?: string;
+    isRegex?: boolean;
+    ignoreCase?: boolean;
+    logic?: string;
+    rules?: ReplaceRule[];
+    pattern?: string;
+    field?: string;
+    disabled?: boolean;
+}
+
+export interface Pipeline {
+    name: string;
+    stages: PipelineStage[];
+}
+
+export interface HighlightRule {
+    name: string;
+    pattern: string;
+    isRegex: boolean;
+    ignoreCase: boolean;
+    color: string;
+    wholeLine: boolean;
+    active: boolean;
+}
+
+export interface HighlightProfile {
+    name: string;
+    rules: HighlightRule[];
+}
+
+export interface AnalyzeOptions {
+    search: SearchOptions;
+    extractors: string[];
+    top: number;
+}
+
+export interface TopItem {
+    value: string;
+    count: number;
+}
+
+export interface TopValues {
+    extractor: string;
+    items: TopItem[];
+    total: number;
+    distinct: number;
+    approximate?: boolean;
+}
+
+export interface AnalyzeReport {
+    lines: number;
+    tops: TopValues[];
+}
+
+export interface RuleCompileError {
+    index: number;
+    name: string;
+    pattern: string;
+    field?: 'replacement' | 'check' | 'guard' | 'scope' | 'scopePattern'; // Part of the rule at fault; unset for the pattern
+    position: number;
+    message: string;
+}
+
+export interface ReplaceRule {
+    name: string;
+    pattern: string;
+    replacement: string;
+    active: boolean;
+    dotAll: boolean;
+    ignoreCase?: boolean;
+    multiline?: boolean;
+    ungreedy?: boolean;
+    wholeWord?: boolean;
+    literal?: boolean;
+    limit?: number;
+    check?: 'luhn' | 'cn-id' | 'ipv6' | 'phone';
+    pseudonym?: string;
+    guard?: string;
+    guardNegate?: boolean;
+    scope?: '' | 'line' | 'group';
+    scopePattern?: string;
+    collapsed: boolean;
+}
+
+export interface TextSpan {
+    start: number;
+    end: number;
+    runeStart: number;
+    runeEnd: number;
+}
+
+export interface TraceStep {
+    index: number;
+    name: string;
+    skipped?: boolean;
+    count: number;
+    matches: { input: TextSpan; output: TextSpan }[];
+    text: string;
+}
+
+export interface ReplaceTrace {
+    input: string;
+    output: string;
+    steps: TraceStep[];
+}
+
+export interface FileApplyOptions {
+    paths: string[];
+    rules: ReplaceRule[];
+    recursive: boolean;
+    include: string;
+    outputDir: string;
+    dryRun: boolean;
+    backup: boolean;
+    wholeFile: boolean;
+}
+
+export interface FileApplyResult {
+    path: string;
+    output?: string;
+    backup?: string;
+    changed: boolean;
+    changedLines: number;
+    preview?: { line: number; before: string; after: string }[];
+    skipped?: string;
+    error?: string;
+}
+
+export interface FileApplySummary {
+    files: FileApplyResult[];
+    changed: number;
+    changedLines: number;
+    failed: number;
+}
+
+export interface ReplaceRuleSet {
+    name: string;
+    rules: ReplaceRule[];
+    tests?: RuleSetTest[];
+    builtin?: boolean;
+}
+
+export interface RuleSetTest {
+    name?: string;
+    input: string;
+    expected: string;
+}
+
+export interface RuleSetTestResult {
+    name?: string;
+    input: string;
+    expected: string;
+    actual: string;
+    passed: boolean;
+    offset: number;
+    diff?: string;
+}
+
+export interface RuleSetTestReport {
+    ruleSet: string;
+    passed: number;
+    failed: number;
+    error?: string;
+    results: RuleSetTestResult[];
+}
+
+export interface RuleSetExportMeta {
+    author?: string;
+    description?: string;
+    version?: string;
+    created: string;
+}
+
+export type ImportStrategy = '' | 'merge' | 'rename' | 'overwrite';
+
+export interface RuleSetImportOptions {
+    data: string;
+    strategy: ImportStrategy;
+    dryRun: boolean;
+}
+
+export interface RuleSetImportResult {
+    meta: RuleSetExportMeta;
+    imported: string[];
+    conflicts: string[];
+    ruleSets: ReplaceRuleSet[];
+    failures: RuleSetTestReport[];
+}
+
+export interface RuleVersion {
+    id: string; // "backup" for the previous save, or a history file name
+    saved: string;
+    ruleSets: number;
+    rules: number;
 }
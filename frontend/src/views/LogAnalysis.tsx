@@ -11,7 +11,6 @@ const LogAnalysis: React.FC = () => {
     const [progress, setProgress] = useState(0);
     const [stats, setStats] = useState({ elapsed: 0, count: 0, status: "" });
     const [filePath, setFilePath] = useState("");
-    const [searchParams, setSearchParams] = useState({ query: "", isRegex: false, ignoreCase: false, invert: false, logic: "AND" });
 
     useEffect(() => {
         // Listen for search results
@@ -50,13 +49,6 @@ const LogAnalysis: React.FC = () => {
         setStats({ elapsed: 0, count: 0, status: "searching" });
         setSearching(true);
         setProgress(0);
-        setSearchParams({ 
-            query, 
-            isRegex: options.isRegex as boolean, 
-            ignoreCase: options.ignoreCase as boolean, 
-            invert: options.invert as boolean,
-            logic: options.logic as string
-        });
 
         try {
             if (AppBackend.Search) {
@@ -110,7 +102,7 @@ const LogAnalysis: React.FC = () => {
                 <SearchBar onSearch={handleSearch} onCancel={handleCancel} searching={searching} setFilePath={setFilePath} />
                 
                 <div className="flex-1 overflow-hidden relative">
-                    <ResultList results={results} searchParams={searchParams} />
+                    <ResultList results={results} />
                 </div>
             </main>
 
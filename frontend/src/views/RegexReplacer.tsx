@@ -1,5 +1,6 @@
 import React, { useState, useEffect, useCallback, useRef } from 'react';
 import * as AppBackend from "../../wailsjs/go/main/App";
+import * as runtime from "../../wailsjs/runtime/runtime";
 import { replacer } from "../../wailsjs/go/models";
 
 const AutoResizingTextarea: React.FC<{
@@ -38,6 +39,14 @@ const RegexReplacer: React.FC = () => {
     const [isAutoReplace, setIsAutoReplace] = useState(true);
     const [draggedIndex, setDraggedIndex] = useState<number | null>(null);
     const [dragOverIndex, setDragOverIndex] = useState<number | null>(null);
+    const [testFailures, setTestFailures] = useState<replacer.TestReport[]>([]);
+    const [recoveryWarning, setRecoveryWarning] = useState("");
+
+    // Registered before loading so a recovery from backup is not missed
+    useEffect(() => {
+        const off = runtime.EventsOn("rules_recovered", (warning: string) => setRecoveryWarning(warning));
+        return () => off();
+    }, []);
 
     // Load rule sets on mount
     useEffect(() => {
@@ -97,7 +106,8 @@ const RegexReplacer: React.FC = () => {
         setRuleSets(updatedSets);
         try {
             if (AppBackend.SaveRuleSets) {
-                await AppBackend.SaveRuleSets(updatedSets);
+                const failures = await AppBackend.SaveRuleSets(updatedSets);
+                setTestFailures(failures || []);
             }
         } catch (err) {
             console.error("保存规则集失败:", err);
@@ -121,12 +131,19 @@ const RegexReplacer: React.FC = () => {
         saveAllRuleSets(newSets);
     };
 
-    const updateRule = (ruleIndex: number, field: keyof replacer.Rule, value: any) => {
-        const newSets = [...ruleSets];
-        (newSets[activeRuleSetIndex].rules[ruleIndex] as any)[field] = value;
+    // Replaces the rule with an updated copy, so fields that change together land in one update
+    const updateRuleFields = (ruleIndex: number, changes: Partial<replacer.Rule>) => {
+        const newSets = ruleSets.map((set, s) => s !== activeRuleSetIndex ? set : replacer.RuleSet.createFrom({
+            ...set,
+            rules: set.rules.map((rule, r) => r === ruleIndex ? replacer.Rule.createFrom({ ...rule, ...changes }) : rule),
+        }));
         saveAllRuleSets(newSets);
     };
 
+    const updateRule = (ruleIndex: number, field: keyof replacer.Rule, value: any) => {
+        updateRuleFields(ruleIndex, { [field]: value });
+    };
+
     const deleteRule = (ruleIndex: number) => {
         const newSets = [...ruleSets];
         newSets[activeRuleSetIndex].rules.splice(ruleIndex, 1);
@@ -189,6 +206,20 @@ const RegexReplacer: React.FC = () => {
             <header className="p-4 border-b border-gray-700 flex justify-between items-center bg-[#1e2a3d]">
                 <h1 className="text-xl font-bold text-green-400">正则文本替换</h1>
                 <div className="flex items-center space-x-4">
+                    {recoveryWarning && (
+                        <span className="text-xs text-yellow-400" title={recoveryWarning}>
+                            ⚠ 规则文件已损坏，已从备份恢复
+                            <button onClick={() => setRecoveryWarning("")} className="ml-1 text-gray-400 hover:text-white">×</button>
+                        </span>
+                    )}
+                    {testFailures.length > 0 && (
+                        <span 
+                            className="text-xs text-yellow-400"
+                            title={testFailures.map(f => f.error || f.results.filter(r => !r.passed).map(r => `${r.name || r.input}\n${r.diff}`).join('\n')).join('\n')}
+                        >
+                            ⚠ 规则集测试未通过: {testFailures.map(f => `${f.ruleSet} (${f.failed})`).join(', ')}
+                        </span>
+                    )}
                     <label className="flex items-center space-x-2 text-xs cursor-pointer">
                         <input 
                             type="checkbox" 
@@ -289,17 +320,80 @@ const RegexReplacer: React.FC = () => {
                                                 className="w-full bg-[#1b2636] text-xs border border-gray-600 rounded px-2 py-1 mt-1 outline-none focus:border-blue-500"
                                             />
                                         </div>
-                                        <div className="flex items-center">
+                                        <div className="flex flex-wrap items-center gap-x-3 gap-y-1">
+                                            {([
+                                                ['dotAll', '匹配换行 (.)'],
+                                                ['ignoreCase', '忽略大小写 (i)'],
+                                                ['multiline', '多行 (m)'],
+                                                ['ungreedy', '非贪婪 (U)'],
+                                                ['wholeWord', '全词匹配'],
+                                                ['literal', '纯文本'],
+                                            ] as [keyof replacer.Rule, string][]).map(([flag, label]) => (
+                                                <label key={flag} className="flex items-center space-x-1 cursor-pointer">
+                                                    <input 
+                                                        type="checkbox" 
+                                                        checked={!!rule[flag]}
+                                                        onChange={(e) => updateRule(i, flag, e.target.checked)}
+                                                        className="rounded w-3 h-3"
+                                                    />
+                                                    <span className="text-[10px] text-gray-300">{label}</span>
+                                                </label>
+                                            ))}
+                                            <label className="flex items-center space-x-1">
+                                                <span className="text-[10px] text-gray-300">仅替换前</span>
+                                                <input 
+                                                    type="number" 
+                                                    min={0}
+                                                    value={rule.limit || 0}
+                                                    onChange={(e) => updateRule(i, 'limit', Math.max(0, parseInt(e.target.value) || 0))}
+                                                    className="w-12 bg-[#1b2636] text-[10px] border border-gray-600 rounded px-1 outline-none focus:border-blue-500"
+                                                    title="0 表示全部替换"
+                                                />
+                                                <span className="text-[10px] text-gray-300">处</span>
+                                            </label>
+                                        </div>
+                                        <div className="flex items-center gap-2">
+                                            <input 
+                                                value={rule.guard || ''}
+                                                onChange={(e) => updateRule(i, 'guard', e.target.value)}
+                                                placeholder="条件模式 (可选)"
+                                                className="flex-1 bg-[#1b2636] text-[10px] border border-gray-600 rounded px-2 py-1 outline-none focus:border-blue-500"
+                                                title="仅在匹配该模式的范围内应用规则"
+                                            />
                                             <label className="flex items-center space-x-1 cursor-pointer">
                                                 <input 
                                                     type="checkbox" 
-                                                    checked={rule.dotAll}
-                                                    onChange={(e) => updateRule(i, 'dotAll', e.target.checked)}
+                                                    checked={!!rule.guardNegate}
+                                                    onChange={(e) => updateRule(i, 'guardNegate', e.target.checked)}
                                                     className="rounded w-3 h-3"
                                                 />
-                                                <span className="text-[10px] text-gray-300">匹配换行 (.)</span>
+                                                <span className="text-[10px] text-gray-300">取反</span>
                                             </label>
                                         </div>
+                                        <div className="flex items-center gap-2">
+                                            <select 
+                                                value={rule.scope || ''}
+                                                onChange={(e) => {
+                                                    const scope = e.target.value;
+                                                    // The pattern input is hidden outside the group scope, so do not keep a stale pattern
+                                                    updateRuleFields(i, scope === 'group' ? { scope } : { scope, scopePattern: '' });
+                                                }}
+                                                className="bg-[#1b2636] text-[10px] border border-gray-600 rounded px-1 py-1 outline-none focus:border-blue-500"
+                                            >
+                                                <option value="">全文</option>
+                                                <option value="line">逐行</option>
+                                                <option value="group">捕获组</option>
+                                            </select>
+                                            {rule.scope === 'group' && (
+                                                <input 
+                                                    value={rule.scopePattern || ''}
+                                                    onChange={(e) => updateRule(i, 'scopePattern', e.target.value)}
+                                                    placeholder={'范围模式，如 "msg":"([^"]*)"'}
+                                                    className="flex-1 bg-[#1b2636] text-[10px] border border-gray-600 rounded px-2 py-1 outline-none focus:border-blue-500"
+                                                    title="规则仅作用于 scope 命名组、第一个捕获组或整个匹配"
+                                                />
+                                            )}
+                                        </div>
                                     </div>
                                 )}
                                 </div>
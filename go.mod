@@ -5,6 +5,7 @@ go 1.22.0
 require (
 	github.com/robotn/gohook v0.42.3
 	github.com/wailsapp/wails/v2 v2.11.0
+	golang.org/x/text v0.22.0
 )
 
 require (
@@ -35,5 +36,4 @@ require (
 	golang.org/x/crypto v0.33.0 // indirect
 	golang.org/x/net v0.35.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
-	golang.org/x/text v0.22.0 // indirect
 )
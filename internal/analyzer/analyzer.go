@@ -0,0 +1,266 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+
+	"logana/internal/replacer"
+	"logana/internal/scanner"
+)
+
+// Supported extractors
+const (
+	ExtractIPv4      = "ipv4"
+	ExtractIPv6      = "ipv6"
+	ExtractRequest   = "request"   // HTTP method and path, without the query string
+	ExtractStatus    = "status"    // HTTP status code
+	ExtractUserAgent = "useragent" // Last quoted field of combined access logs
+	ExtractEmail     = "email"
+	ExtractUUID      = "uuid"
+	ExtractMessage   = "message" // Whole line with volatile fields masked, for "top errors"
+)
+
+const defaultTop = 20
+
+// maxDistinct bounds the values a worker keeps per extractor; beyond it, the
+// rarest values are dropped and the counts become approximate
+var maxDistinct = 1 << 20
+
+// Options defines the parameters of a top-N analysis
+type Options struct {
+	Search     scanner.SearchOptions `json:"search"`     // Files and query selecting the lines to analyze
+	Extractors []string              `json:"extractors"` // Empty for all extractors
+	Top        int                   `json:"top"`        // Values reported per extractor; 0 for 20
+}
+
+// Item is one value and how often it occurred
+type Item struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Top holds the most frequent values of one extractor
+type Top struct {
+	Extractor   string `json:"extractor"`
+	Items       []Item `json:"items"`
+	Total       int64  `json:"total"`    // Occurrences of all values
+	Distinct    int    `json:"distinct"` // Distinct values counted
+	Approximate bool   `json:"approximate,omitempty"`
+}
+
+// Report is the outcome of an analysis
+type Report struct {
+	Lines int64 `json:"lines"` // Lines analyzed
+	Tops  []Top `json:"tops"`
+}
+
+// extractor pulls values of one kind out of a line
+type extractor struct {
+	re    *regexp.Regexp          // nil passes the whole line
+	all   bool                    // Count every occurrence, not just the first
+	value func(m []string) string // Builds the value from the submatches; "" skips it
+	// check rejects lookalike matches given the line and the match bounds.
+	// value then only gets the whole match.
+	check func(text string, start, end int) bool
+}
+
+// newExtractor builds the extractor with the given name
+func newExtractor(name string) (*extractor, error) {
+	ex := &extractor{}
+	switch name {
+	case ExtractIPv4:
+		ex.re = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)
+		ex.all = true
+		ex.value = func(m []string) string { return m[0] }
+	case ExtractIPv6:
+		ex.re = regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`)
+		ex.all = true
+		ex.check = replacer.IPv6Valid // Times such as 12:30:45 and names such as std::map look alike
+		ex.value = func(m []string) string {
+			addr := netip.MustParseAddr(m[0])
+			if addr.IsUnspecified() {
+				return ""
+			}
+			return addr.String()
+		}
+	case ExtractRequest:
+		ex.re = regexp.MustCompile(`\b(GET|POST|PUT|DELETE|PATCH|HEAD|OPTIONS|CONNECT|TRACE) (/\S*|https?://\S+)`)
+		ex.value = func(m []string) string {
+			path, _, _ := strings.Cut(m[2], "?")
+			return m[1] + " " + path
+		}
+	case ExtractStatus:
+		ex.re = regexp.MustCompile(`"\s+([1-5]\d\d)\s|\bstatus"?\s*[=:]\s*"?([1-5]\d\d)\b`)
+		ex.value = func(m []string) string {
+			if m[1] != "" {
+				return m[1]
+			}
+			return m[2]
+		}
+	case ExtractUserAgent:
+		ex.re = regexp.MustCompile(`"[^"]*" "([^"]*)"\s*$`)
+		ex.value = func(m []string) string { return m[1] }
+	case ExtractEmail:
+		ex.re = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+		ex.all = true
+		ex.value = func(m []string) string { return strings.ToLower(m[0]) }
+	case ExtractUUID:
+		ex.re = regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`)
+		ex.all = true
+		ex.value = func(m []string) string { return strings.ToLower(m[0]) }
+	case ExtractMessage:
+		masks, err := replacer.Compile(replacer.DedupeRules())
+		if err != nil {
+			return nil, err
+		}
+		ex.value = func(m []string) string {
+			return strings.TrimSpace(masks.Replace(m[0]))
+		}
+	default:
+		return nil, fmt.Errorf("unknown extractor '%s'", name)
+	}
+	return ex, nil
+}
+
+// Extractors returns the names of the supported extractors
+func Extractors() []string {
+	return []string{ExtractIPv4, ExtractIPv6, ExtractRequest, ExtractStatus, ExtractUserAgent, ExtractEmail, ExtractUUID, ExtractMessage}
+}
+
+// find returns the submatches of up to n matches in line; n < 0 finds all
+func (ex *extractor) find(line string, n int) [][]string {
+	if ex.check == nil {
+		return ex.re.FindAllStringSubmatch(line, n)
+	}
+	var matches [][]string
+	for _, loc := range ex.re.FindAllStringIndex(line, n) {
+		if ex.check(line, loc[0], loc[1]) {
+			matches = append(matches, []string{line[loc[0]:loc[1]]})
+		}
+	}
+	return matches
+}
+
+// counter accumulates the values of one worker
+type counter struct {
+	extractors []*extractor
+	counts     []map[string]int64
+	pruned     []bool
+	lines      int64
+}
+
+func (c *counter) visit(file, line string, fields map[string]string) {
+	c.lines++
+	for i, ex := range c.extractors {
+		matches := [][]string{{line}}
+		if ex.re != nil {
+			n := 1
+			if ex.all {
+				n = -1
+			}
+			matches = ex.find(line, n)
+		}
+		for _, m := range matches {
+			v := ex.value(m)
+			if v == "" {
+				continue
+			}
+			counts := c.counts[i]
+			counts[v]++
+			if len(counts) > maxDistinct {
+				prune(counts)
+				c.pruned[i] = true
+			}
+		}
+	}
+}
+
+// prune drops the rarest values until at most maxDistinct/2 are left, so the
+// next prune is at least maxDistinct/2 new values away
+func prune(counts map[string]int64) {
+	ns := make([]int64, 0, len(counts))
+	for _, n := range counts {
+		ns = append(ns, n)
+	}
+	sort.Slice(ns, func(a, b int) bool { return ns[a] > ns[b] })
+	cutoff := ns[maxDistinct/2]
+	for k, n := range counts {
+		if n <= cutoff {
+			delete(counts, k)
+		}
+	}
+}
+
+// Analyze counts the values found by the selected extractors in the lines
+// matching opts.Search and reports the most frequent ones. Chunks are
+// processed in parallel by the scanner; each worker counts into its own maps,
+// which are merged once the scan is finished.
+func Analyze(ctx context.Context, ps *scanner.ParallelScanner, opts Options, progress chan<- float64) (Report, error) {
+	var report Report
+
+	names := opts.Extractors
+	if len(names) == 0 {
+		names = Extractors()
+	}
+	top := opts.Top
+	if top <= 0 {
+		top = defaultTop
+	}
+
+	var list []*extractor
+	for _, name := range names {
+		ex, err := newExtractor(name)
+		if err != nil {
+			return report, err
+		}
+		list = append(list, ex)
+	}
+
+	// Visitors are created up front, one per worker, so counters needs no lock
+	var counters []*counter
+	err := ps.Visit(ctx, opts.Search, progress, func() scanner.VisitFunc {
+		c := &counter{extractors: list, pruned: make([]bool, len(list))}
+		for range list {
+			c.counts = append(c.counts, make(map[string]int64))
+		}
+		counters = append(counters, c)
+		return c.visit
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for i, name := range names {
+		merged := make(map[string]int64)
+		t := Top{Extractor: name, Items: []Item{}}
+		for _, c := range counters {
+			for v, n := range c.counts[i] {
+				merged[v] += n
+				t.Total += n
+			}
+			t.Approximate = t.Approximate || c.pruned[i]
+		}
+		t.Distinct = len(merged)
+		for v, n := range merged {
+			t.Items = append(t.Items, Item{Value: v, Count: n})
+		}
+		sort.Slice(t.Items, func(a, b int) bool {
+			if t.Items[a].Count != t.Items[b].Count {
+				return t.Items[a].Count > t.Items[b].Count
+			}
+			return t.Items[a].Value < t.Items[b].Value
+		})
+		if len(t.Items) > top {
+			t.Items = t.Items[:top]
+		}
+		report.Tops = append(report.Tops, t)
+	}
+	for _, c := range counters {
+		report.Lines += c.lines
+	}
+	return report, nil
+}
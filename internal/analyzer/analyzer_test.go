@@ -0,0 +1,130 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"logana/internal/scanner"
+)
+
+// countValues runs one extractor over lines and formats the counts as "value=n", sorted
+func countValues(t *testing.T, name string, lines []string) string {
+	t.Helper()
+	ex, err := newExtractor(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &counter{extractors: []*extractor{ex}, counts: []map[string]int64{{}}, pruned: []bool{false}}
+	for _, line := range lines {
+		c.visit("app.log", line, nil)
+	}
+	var got []string
+	for v, n := range c.counts[0] {
+		got = append(got, fmt.Sprintf("%s=%d", v, n))
+	}
+	sort.Strings(got)
+	return strings.Join(got, " ")
+}
+
+func TestExtractors(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{ExtractIPv4, []string{"from 10.0.0.1 to 192.168.1.20", "again 10.0.0.1", "not 300.1.1.1 or 10.0.0"}, "10.0.0.1=2 192.168.1.20=1"},
+		{ExtractIPv6, []string{"client 2001:DB8::1 port 443", "fe80::1%eth0 and 2001:db8:0:0:0:0:0:1"}, "2001:db8::1=2 fe80::1=1"},
+		{ExtractIPv6, []string{"at 12:30:45", "Foo::Bar", "ActiveRecord::Base", "std::deque", "mac 00:1a:2b:3c:4d:5e:6f:70:81", "bind ::"}, ""},
+		{ExtractRequest, []string{`"GET /api/users?id=7 HTTP/1.1" 200`, `"GET /api/users HTTP/1.1" 404`, `POST https://example.com/login`}, "GET /api/users=2 POST https://example.com/login=1"},
+		{ExtractStatus, []string{`"GET / HTTP/1.1" 200 512`, `status=503 upstream`, `{"status": "404"}`, `took 200 ms`}, "200=1 404=1 503=1"},
+		{ExtractUserAgent, []string{`"GET / HTTP/1.1" 200 5 "-" "curl/8.0"`, `"GET / HTTP/1.1" 200 5 "-" "curl/8.0"`, `no quotes`}, "curl/8.0=2"},
+		{ExtractEmail, []string{"to Ops@Example.com and dev@example.org", "ops@example.com", "user@localhost"}, "dev@example.org=1 ops@example.com=2"},
+		{ExtractUUID, []string{"req 7F3A9C2E-1B4D-4E8A-9C3F-2A1B3C4D5E6F", "req 7f3a9c2e-1b4d-4e8a-9c3f-2a1b3c4d5e6f done"}, "7f3a9c2e-1b4d-4e8a-9c3f-2a1b3c4d5e6f=2"},
+		{ExtractMessage, []string{"timeout after 1500 ms ", "timeout after 30 ms", "pid 42 exited"}, "pid <PID> exited=1 timeout after <N> ms=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countValues(t, tt.name, tt.lines); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newExtractor("phone"); err == nil {
+		t.Error("unknown extractor accepted")
+	}
+}
+
+func TestCounterCap(t *testing.T) {
+	defer func(limit int) { maxDistinct = limit }(maxDistinct)
+	maxDistinct = 8
+
+	ex, _ := newExtractor(ExtractMessage)
+	c := &counter{extractors: []*extractor{ex}, counts: []map[string]int64{{}}, pruned: []bool{false}}
+	for i := 0; i < 3; i++ {
+		c.visit("app.log", "frequent", nil)
+	}
+	// Values seen more than once survive the prune as long as they are among the most common
+	for i := 0; i < 100; i++ {
+		c.visit("app.log", fmt.Sprintf("rare %c", 'a'+i%26), nil)
+		c.visit("app.log", fmt.Sprintf("once %d", i), nil)
+	}
+
+	counts := c.counts[0]
+	if !c.pruned[0] || len(counts) > maxDistinct {
+		t.Errorf("pruned %v with %d values, want at most %d", c.pruned[0], len(counts), maxDistinct)
+	}
+	if counts["frequent"] != 3 {
+		t.Errorf("the most frequent value was dropped: %v", counts)
+	}
+
+	// Values all seen twice must still be pruned, down to half the cap
+	counts = map[string]int64{"top": 5}
+	for i := 0; i < maxDistinct; i++ {
+		counts[fmt.Sprint(i)] = 2
+	}
+	prune(counts)
+	if len(counts) > maxDistinct/2 || counts["top"] != 5 {
+		t.Errorf("after pruning: %v", counts)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	var lines []string
+	for i := 0; i < 30; i++ {
+		status := 200
+		if i%3 == 0 {
+			status = 500
+		}
+		lines = append(lines, fmt.Sprintf(`10.0.0.%d - - "GET /api/item?id=%d HTTP/1.1" %d 12`, i%4, i, status))
+	}
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Search: scanner.SearchOptions{FilePath: path, Query: "GET"}, Extractors: []string{ExtractIPv4, ExtractStatus}, Top: 2}
+	report, err := Analyze(context.Background(), scanner.NewParallelScanner(2), opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Lines != 30 || len(report.Tops) != 2 {
+		t.Fatalf("got %d lines and %d tops", report.Lines, len(report.Tops))
+	}
+
+	ips := report.Tops[0]
+	if ips.Total != 30 || ips.Distinct != 4 || len(ips.Items) != 2 || ips.Approximate {
+		t.Errorf("ipv4: got %+v", ips)
+	}
+	if ips.Items[0] != (Item{Value: "10.0.0.0", Count: 8}) || ips.Items[1] != (Item{Value: "10.0.0.1", Count: 8}) {
+		t.Errorf("ipv4: ties must be ordered by value, got %+v", ips.Items)
+	}
+	if status := report.Tops[1]; len(status.Items) != 2 || status.Items[0] != (Item{Value: "200", Count: 20}) || status.Items[1] != (Item{Value: "500", Count: 10}) {
+		t.Errorf("status: got %+v", status.Items)
+	}
+}
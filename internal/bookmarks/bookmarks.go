@@ -0,0 +1,258 @@
+package bookmarks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"logana/internal/fileutil"
+)
+
+// headSize is how much of the file start is hashed to recognize a file
+// even after it was renamed or has grown
+const headSize = 64 * 1024
+
+// DefaultColor is used when a bookmark is added without a color
+const DefaultColor = "yellow"
+
+// FileIdentity identifies the log file a bookmark belongs to
+type FileIdentity struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	HeadHash string    `json:"headHash"` // SHA-256 of the first 64KB
+}
+
+// Bookmark marks a line of a log file with a note
+type Bookmark struct {
+	ID        string       `json:"id"`
+	File      FileIdentity `json:"file"`
+	Offset    int64        `json:"offset"`
+	Line      int          `json:"line,omitempty"`
+	Snippet   string       `json:"snippet"`
+	Note      string       `json:"note"`
+	Color     string       `json:"color"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+// Store persists bookmarks for all files
+type Store struct {
+	configPath string
+	mu         sync.Mutex
+}
+
+func NewStore(appConfigDir string) *Store {
+	configPath := filepath.Join(appConfigDir, "bookmarks.json")
+	return &Store{
+		configPath: configPath,
+	}
+}
+
+// Identify computes the identity of a file on disk
+func Identify(path string) (FileIdentity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileIdentity{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return FileIdentity{}, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.LimitReader(file, headSize)); err != nil {
+		return FileIdentity{}, err
+	}
+
+	return FileIdentity{
+		Path:     path,
+		Size:     stat.Size(),
+		ModTime:  stat.ModTime(),
+		HeadHash: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// Same reports whether two identities refer to the same log. The head hash
+// survives renames and appends; files shorter than the hashed head are still
+// growing into it, so they fall back to the path.
+func (id FileIdentity) Same(other FileIdentity) bool {
+	if id.Size >= headSize && other.Size >= headSize {
+		return id.HeadHash == other.HeadHash
+	}
+	return id.Path == other.Path
+}
+
+// Add creates a bookmark on the file at b.File.Path
+func (s *Store) Add(b Bookmark) (Bookmark, error) {
+	ident, err := Identify(b.File.Path)
+	if err != nil {
+		return Bookmark{}, err
+	}
+	if b.Offset < 0 || b.Offset > ident.Size {
+		return Bookmark{}, fmt.Errorf("offset %d is outside of %s", b.Offset, b.File.Path)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return Bookmark{}, err
+	}
+
+	now := time.Now()
+	b.ID = strconv.FormatInt(now.UnixNano(), 36)
+	b.File = ident
+	b.CreatedAt = now
+	b.UpdatedAt = now
+	if b.Color == "" {
+		b.Color = DefaultColor
+	}
+
+	all = append(all, b)
+	return b, s.save(all)
+}
+
+// Update changes the note and color of a bookmark
+func (s *Store) Update(id, note, color string) (Bookmark, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return Bookmark{}, err
+	}
+	for i := range all {
+		if all[i].ID != id {
+			continue
+		}
+		all[i].Note = note
+		if color != "" {
+			all[i].Color = color
+		}
+		all[i].UpdatedAt = time.Now()
+		return all[i], s.save(all)
+	}
+	return Bookmark{}, fmt.Errorf("bookmark '%s' not found", id)
+}
+
+// Delete removes a bookmark
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	kept := all[:0]
+	for _, b := range all {
+		if b.ID != id {
+			kept = append(kept, b)
+		}
+	}
+	return s.save(kept)
+}
+
+// List returns the bookmarks of a file ordered by offset
+func (s *Store) List(path string) ([]Bookmark, error) {
+	ident, err := Identify(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	found := []Bookmark{}
+	for _, b := range all {
+		if b.File.Same(ident) {
+			found = append(found, b)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Offset < found[j].Offset })
+	return found, nil
+}
+
+// Timeline renders the bookmarks of a file as a Markdown incident timeline
+func (s *Store) Timeline(path string) (string, error) {
+	marks, err := s.List(path)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Incident timeline: %s\n\n", filepath.Base(path))
+	fmt.Fprintf(&sb, "File: `%s`  \nExported: %s\n\n", path, time.Now().Format(time.RFC3339))
+	if len(marks) == 0 {
+		sb.WriteString("_No bookmarks._\n")
+		return sb.String(), nil
+	}
+
+	for i, b := range marks {
+		location := fmt.Sprintf("offset %d", b.Offset)
+		if b.Line > 0 {
+			location = fmt.Sprintf("line %d, %s", b.Line, location)
+		}
+		note := b.Note
+		if note == "" {
+			note = "(no note)"
+		}
+		fmt.Fprintf(&sb, "## %d. %s\n\n", i+1, note)
+		fmt.Fprintf(&sb, "- Location: %s\n- Color: %s\n\n", location, b.Color)
+		if b.Snippet != "" {
+			fmt.Fprintf(&sb, "```\n%s\n```\n\n", b.Snippet)
+		}
+	}
+	return sb.String(), nil
+}
+
+func (s *Store) load() ([]Bookmark, error) {
+	if _, err := os.Stat(s.configPath); os.IsNotExist(err) {
+		return []Bookmark{}, nil
+	}
+
+	data, err := os.ReadFile(s.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []Bookmark
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *Store) save(all []Bookmark) error {
+	dir := filepath.Dir(s.configPath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteAtomic(s.configPath, data, 0o644)
+}
@@ -0,0 +1,129 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"logana/internal/scanner"
+)
+
+// Supported export formats
+const (
+	FormatText  = "txt"
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// Writer streams matches to an output in one of the export formats
+type Writer struct {
+	format  string
+	buf     *bufio.Writer
+	csv     *csv.Writer
+	json    *json.Encoder
+	fields  []string // Extracted field columns for CSV
+	context bool     // Whether matches carry context lines (text format separates groups)
+	written int64
+}
+
+// NewWriter creates a writer for the given format. fields lists the extracted
+// field columns appended to CSV rows; context enables grep-style "--" group separators.
+func NewWriter(w io.Writer, format string, fields []string, context bool) (*Writer, error) {
+	buf := bufio.NewWriterSize(w, 1024*1024)
+	ew := &Writer{
+		format:  strings.ToLower(format),
+		buf:     buf,
+		fields:  fields,
+		context: context,
+	}
+
+	switch ew.format {
+	case FormatText:
+	case FormatJSONL:
+		ew.json = json.NewEncoder(buf)
+		ew.json.SetEscapeHTML(false)
+	case FormatCSV:
+		ew.csv = csv.NewWriter(buf)
+		header := append([]string{"file", "line", "offset", "content"}, fields...)
+		if err := ew.csv.Write(header); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported export format '%s'", format)
+	}
+	return ew, nil
+}
+
+// Write appends one match to the output
+func (ew *Writer) Write(m scanner.Match) error {
+	var err error
+	switch ew.format {
+	case FormatText:
+		err = ew.writeText(m)
+	case FormatJSONL:
+		err = ew.json.Encode(m)
+	case FormatCSV:
+		row := []string{m.File, strconv.Itoa(m.LineNumber), strconv.FormatInt(m.Offset, 10), m.Content}
+		for _, f := range ew.fields {
+			row = append(row, m.Fields[f])
+		}
+		err = ew.csv.Write(row)
+	}
+	if err == nil {
+		ew.written++
+	}
+	return err
+}
+
+// writeText prints a match like grep -n -H: "file:line:content" for the matched
+// line and "file-line-content" for context lines
+func (ew *Writer) writeText(m scanner.Match) error {
+	if ew.context && ew.written > 0 {
+		if _, err := ew.buf.WriteString("--\n"); err != nil {
+			return err
+		}
+	}
+
+	if m.Binary {
+		_, err := ew.buf.WriteString(m.Content + "\n")
+		return err
+	}
+
+	for i, line := range strings.Split(m.Content, "\n") {
+		sep := ":"
+		if i != m.ContextBefore {
+			sep = "-"
+		}
+		prefix := ""
+		if m.File != "" {
+			prefix = m.File + sep
+		}
+		if m.LineNumber > 0 {
+			prefix += strconv.Itoa(m.LineNumber-m.ContextBefore+i) + sep
+		}
+		if _, err := ew.buf.WriteString(prefix + line + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count returns the number of matches written so far
+func (ew *Writer) Count() int64 {
+	return ew.written
+}
+
+// Flush writes any buffered data to the underlying writer
+func (ew *Writer) Flush() error {
+	if ew.csv != nil {
+		ew.csv.Flush()
+		if err := ew.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return ew.buf.Flush()
+}
@@ -0,0 +1,78 @@
+package exporter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"logana/internal/scanner"
+)
+
+var testMatches = []scanner.Match{
+	{File: "app.log", LineNumber: 3, Offset: 40, Content: "ERROR db down", Fields: map[string]string{"level": "ERROR"}},
+	{File: "app.log", LineNumber: 9, Offset: 120, Content: `said "hi", <b> & left`},
+}
+
+// export writes matches in a format and returns the output
+func export(t *testing.T, format string, fields []string, context bool, matches []scanner.Match) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format, fields, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range matches {
+		if err := w.Write(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Count() != int64(len(matches)) {
+		t.Errorf("counted %d matches, wrote %d", w.Count(), len(matches))
+	}
+	return buf.String()
+}
+
+func TestExportFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		fields  []string
+		context bool
+		matches []scanner.Match
+		want    string
+	}{
+		{"text", FormatText, nil, false, testMatches,
+			"app.log:3:ERROR db down\napp.log:9:said \"hi\", <b> & left\n"},
+		{"text without file or line", "TXT", nil, false, []scanner.Match{{Content: "plain"}},
+			"plain\n"},
+		{"text with context", FormatText, nil, true, []scanner.Match{
+			{File: "a.log", LineNumber: 5, Content: "four\nfive\nsix", ContextBefore: 1},
+			{File: "a.log", LineNumber: 20, Content: "twenty\nnext", ContextBefore: 0},
+		}, "a.log-4-four\na.log:5:five\na.log-6-six\n--\na.log:20:twenty\na.log-21-next\n"},
+		{"text binary notice", FormatText, nil, false, []scanner.Match{{File: "a.bin", Content: "Binary file a.bin matches", Binary: true}},
+			"Binary file a.bin matches\n"},
+		{"jsonl", FormatJSONL, nil, false, testMatches,
+			`{"file":"app.log","line":3,"content":"ERROR db down","offset":40,"fields":{"level":"ERROR"}}` + "\n" +
+				`{"file":"app.log","line":9,"content":"said \"hi\", <b> & left","offset":120}` + "\n"},
+		{"csv", FormatCSV, []string{"level", "user"}, false, testMatches,
+			"file,line,offset,content,level,user\napp.log,3,40,ERROR db down,ERROR,\napp.log,9,120,\"said \"\"hi\"\", <b> & left\",,\n"},
+		{"csv header only", FormatCSV, nil, false, nil,
+			"file,line,offset,content\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := export(t, tt.format, tt.fields, tt.context, tt.matches); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if _, err := NewWriter(&bytes.Buffer{}, "xml", nil, false); err == nil || !strings.Contains(err.Error(), "unsupported export format") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Supported format names
+const (
+	FormatAuto         = "auto"
+	FormatCombined     = "combined"      // Nginx/Apache common and combined access logs
+	FormatRFC3164      = "rfc3164"       // BSD syslog
+	FormatRFC5424      = "rfc5424"       // IETF syslog
+	FormatDocker       = "docker"        // Docker json-file driver
+	FormatCRI          = "cri"           // Kubernetes CRI container logs
+	FormatJournald     = "journald"      // journald export stream, as written by journalctl -o export
+	FormatJournaldJSON = "journald-json" // journald entries as exported by journalctl -o json
+)
+
+// Standard fields set by every format when present in the line
+const (
+	FieldTimestamp = "timestamp"
+	FieldLevel     = "level"
+	FieldSource    = "source"
+	FieldMessage   = "message"
+)
+
+// detectLines is how many non-empty lines of the sample are tried by Detect
+const detectLines = 20
+
+// Format parses lines of one log format
+type Format struct {
+	Name    string
+	Fields  []string // Standard fields followed by format-specific ones
+	Records bool     // Entries span several lines and are read with NewJournalReader; Parse rejects every line
+	parse   func(line string, fields map[string]string) bool
+}
+
+// Parse splits a line into named fields. It returns false if the line is not in this format.
+func (f *Format) Parse(line string) (map[string]string, bool) {
+	if f.parse == nil {
+		return nil, false
+	}
+	fields := make(map[string]string, len(f.Fields))
+	if !f.parse(line, fields) {
+		return nil, false
+	}
+	dropEmpty(fields)
+	return fields, true
+}
+
+// dropEmpty removes fields that were absent or "-" in the entry
+func dropEmpty(fields map[string]string) {
+	for k, v := range fields {
+		if v == "" || v == "-" {
+			delete(fields, k)
+		}
+	}
+}
+
+var standardFields = []string{FieldTimestamp, FieldLevel, FieldSource, FieldMessage}
+
+// all lists the formats in detection order, most specific first
+var all = []*Format{
+	{Name: FormatDocker, Fields: withStandard("stream"), parse: parseDocker},
+	{Name: FormatJournald, Fields: withStandard(journalFields...), Records: true},
+	{Name: FormatJournaldJSON, Fields: withStandard(journalFields...), parse: parseJournaldJSON},
+	{Name: FormatCRI, Fields: withStandard("partial"), parse: parseCRI},
+	{Name: FormatRFC5424, Fields: withStandard("hostname", "procid", "msgid", "data"), parse: parseRFC5424},
+	{Name: FormatRFC3164, Fields: withStandard("hostname", "pid"), parse: parseRFC3164},
+	{Name: FormatCombined, Fields: withStandard("method", "path", "status", "bytes", "referer", "useragent"), parse: parseCombined},
+}
+
+func withStandard(extra ...string) []string {
+	return append(append([]string{}, standardFields...), extra...)
+}
+
+// Names returns the names of the supported formats
+func Names() []string {
+	names := make([]string, 0, len(all))
+	for _, f := range all {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// Get returns the format with the given name
+func Get(name string) (*Format, error) {
+	for _, f := range all {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported log format '%s'", name)
+}
+
+// StandardFields returns the fields every format fills in when it can
+func StandardFields() []string {
+	return append([]string{}, standardFields...)
+}
+
+// Detect picks the format that parses most of the first lines of a sample.
+// It returns nil if no format parses at least half of them. A journal export
+// stream is recognized by its first record instead.
+func Detect(sample []byte) *Format {
+	if isJournalExport(sample) {
+		f, _ := Get(FormatJournald)
+		return f
+	}
+
+	var lines []string
+	for _, line := range bytes.Split(sample, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			continue
+		}
+		lines = append(lines, string(line))
+		if len(lines) == detectLines {
+			break
+		}
+	}
+	// The last line of a sample is usually cut short
+	if len(lines) > 1 && len(lines) < detectLines {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var best *Format
+	bestHits := 0
+	for _, f := range all {
+		if f.parse == nil {
+			continue
+		}
+		hits := 0
+		fields := make(map[string]string)
+		for _, line := range lines {
+			if f.parse(line, fields) {
+				hits++
+			}
+		}
+		if hits > bestHits {
+			best, bestHits = f, hits
+		}
+	}
+	if bestHits*2 < len(lines) {
+		return nil
+	}
+	return best
+}
+
+// severities maps syslog severities (PRI % 8) to level names
+var severities = []string{"emerg", "alert", "crit", "error", "warning", "notice", "info", "debug"}
+
+func severity(pri string) string {
+	n, err := strconv.Atoi(pri)
+	if err != nil || n < 0 || n > 191 {
+		return ""
+	}
+	return severities[n%8]
+}
+
+var combinedRe = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "([^"]*)" (\d{3}) (\S+)(?: "([^"]*)" "([^"]*)")?`)
+
+func parseCombined(line string, fields map[string]string) bool {
+	m := combinedRe.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	fields[FieldSource] = m[1]
+	fields[FieldTimestamp] = m[2]
+	fields[FieldMessage] = m[3]
+	if method, rest, ok := strings.Cut(m[3], " "); ok {
+		fields["method"] = method
+		fields["path"], _, _ = strings.Cut(rest, " ")
+	}
+	fields["status"] = m[4]
+	fields["bytes"] = m[5]
+	fields["referer"] = m[6]
+	fields["useragent"] = m[7]
+	switch m[4][0] {
+	case '5':
+		fields[FieldLevel] = "error"
+	case '4':
+		fields[FieldLevel] = "warning"
+	default:
+		fields[FieldLevel] = "info"
+	}
+	return true
+}
+
+var rfc3164Re = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (\S+) ([^:\[\s]+)(?:\[(\d+)\])?: ?(.*)$`)
+
+func parseRFC3164(line string, fields map[string]string) bool {
+	m := rfc3164Re.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	fields[FieldLevel] = severity(m[1])
+	fields[FieldTimestamp] = m[2]
+	fields["hostname"] = m[3]
+	fields[FieldSource] = m[4]
+	fields["pid"] = m[5]
+	fields[FieldMessage] = m[6]
+	return true
+}
+
+var rfc5424Re = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\S+) (\S+) (-|(?:\[(?:[^\]"]|"(?:[^"\\]|\\.)*")*\])+)(?: (.*))?$`)
+
+func parseRFC5424(line string, fields map[string]string) bool {
+	m := rfc5424Re.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	fields[FieldLevel] = severity(m[1])
+	fields[FieldTimestamp] = m[2]
+	fields["hostname"] = m[3]
+	fields[FieldSource] = m[4]
+	fields["procid"] = m[5]
+	fields["msgid"] = m[6]
+	fields["data"] = m[7]
+	fields[FieldMessage] = strings.TrimPrefix(m[8], "\ufeff") // MSG may start with a UTF-8 BOM
+	return true
+}
+
+func parseDocker(line string, fields map[string]string) bool {
+	if !strings.HasPrefix(line, `{"log":`) {
+		return false
+	}
+	var entry struct {
+		Log    string `json:"log"`
+		Stream string `json:"stream"`
+		Time   string `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return false
+	}
+	fields[FieldTimestamp] = entry.Time
+	fields[FieldSource] = entry.Stream
+	fields["stream"] = entry.Stream
+	fields[FieldMessage] = strings.TrimRight(entry.Log, "\r\n")
+	return true
+}
+
+var criRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\S+) (stdout|stderr) ([FP]) (.*)$`)
+
+func parseCRI(line string, fields map[string]string) bool {
+	m := criRe.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	fields[FieldTimestamp] = m[1]
+	fields[FieldSource] = m[2]
+	if m[3] == "P" {
+		fields["partial"] = "true" // The entry continues on the next line
+	}
+	fields[FieldMessage] = m[4]
+	return true
+}
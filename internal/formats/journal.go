@@ -0,0 +1,225 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxJournalField bounds the length of a binary field, so a corrupt length
+// does not make the reader allocate the whole file or more
+const maxJournalField = 64 << 20
+
+// journalFields are the format-specific fields of both journald formats
+var journalFields = []string{"priority", "unit", "pid", "hostname"}
+
+// JournalEntry is one record of a journal export stream
+type JournalEntry struct {
+	Offset int64             // Byte offset of the record in the stream
+	Fields map[string]string // Journal fields such as MESSAGE or _PID; binary values are kept as they are
+}
+
+// JournalReader reads the records of a journal export stream (journalctl -o
+// export). Each field is a KEY=VALUE line, or for values that are not plain
+// text the key on its own line followed by a 64-bit little-endian length, the
+// data and a newline. Records end with a blank line.
+type JournalReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+// NewJournalReader reads records from r, which starts at offset in the file
+func NewJournalReader(r io.Reader, offset int64) *JournalReader {
+	return &JournalReader{r: bufio.NewReaderSize(r, 64*1024), offset: offset}
+}
+
+// Next returns the next record, or io.EOF after the last one. A record cut off
+// at the end of the stream is returned as it is, unless it stops inside a
+// binary field: then the fields read so far come with io.ErrUnexpectedEOF.
+// When a key appears more than once in a record, the first value is kept.
+func (jr *JournalReader) Next() (JournalEntry, error) {
+	entry := JournalEntry{Offset: jr.offset, Fields: make(map[string]string)}
+	set := func(key, value string) {
+		if _, ok := entry.Fields[key]; !ok {
+			entry.Fields[key] = value
+		}
+	}
+
+	for {
+		line, err := jr.r.ReadString('\n')
+		jr.offset += int64(len(line))
+		if err != nil && err != io.EOF {
+			return entry, err
+		}
+		eof := err == io.EOF
+		line = strings.TrimSuffix(line, "\n")
+
+		if line == "" {
+			switch {
+			case len(entry.Fields) > 0:
+				return entry, nil
+			case eof:
+				return entry, io.EOF
+			}
+			entry.Offset = jr.offset // Extra blank lines between records
+			continue
+		}
+
+		if key, value, ok := strings.Cut(line, "="); ok {
+			if !validJournalKey(key) {
+				return entry, fmt.Errorf("invalid journal field '%s' at offset %d", key, jr.offset-int64(len(line))-1)
+			}
+			set(key, value)
+		} else {
+			if !validJournalKey(line) {
+				return entry, fmt.Errorf("invalid journal field '%s' at offset %d", line, jr.offset-int64(len(line))-1)
+			}
+			if eof {
+				return entry, io.ErrUnexpectedEOF
+			}
+			value, err := jr.binaryValue(line)
+			if err != nil {
+				return entry, err
+			}
+			set(line, value)
+			continue
+		}
+		if eof {
+			return entry, nil
+		}
+	}
+}
+
+// binaryValue reads the length-prefixed value of a binary field and the newline after it
+func (jr *JournalReader) binaryValue(key string) (string, error) {
+	var size [8]byte
+	if _, err := io.ReadFull(jr.r, size[:]); err != nil {
+		return "", unexpected(err)
+	}
+	jr.offset += 8
+	n := binary.LittleEndian.Uint64(size[:])
+	if n > maxJournalField {
+		return "", fmt.Errorf("journal field '%s' at offset %d is too long (%d bytes)", key, jr.offset-8, n)
+	}
+	data := make([]byte, n+1)
+	if _, err := io.ReadFull(jr.r, data); err != nil {
+		return "", unexpected(err)
+	}
+	jr.offset += int64(n + 1)
+	if data[n] != '\n' {
+		return "", fmt.Errorf("journal field '%s' is not followed by a newline at offset %d", key, jr.offset-1)
+	}
+	return string(data[:n]), nil
+}
+
+// unexpected reports a stream that ends inside a field as io.ErrUnexpectedEOF
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// validJournalKey reports whether key is a journal field name: upper case
+// letters, digits and underscores, not starting with a digit
+func validJournalKey(key string) bool {
+	if key == "" || key[0] >= '0' && key[0] <= '9' {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// isJournalExport reports whether a sample starts with a journal export record
+func isJournalExport(sample []byte) bool {
+	entry, err := NewJournalReader(bytes.NewReader(sample), 0).Next()
+	// The sample may end inside a binary field of the first record
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+	_, ok := entry.Fields["__REALTIME_TIMESTAMP"]
+	return ok
+}
+
+// Parse maps the entry to the fields of FormatJournald, and renders it on one
+// line the way journalctl shows it: timestamp, host, source[pid]: message.
+// Newlines in the message are shown as spaces; the message field keeps them.
+func (e JournalEntry) Parse() (string, map[string]string) {
+	fields := make(map[string]string, len(standardFields)+len(journalFields))
+	setJournalFields(func(key string) string { return e.Fields[key] }, fields)
+	dropEmpty(fields)
+
+	var sb strings.Builder
+	for _, part := range []string{fields[FieldTimestamp], fields["hostname"]} {
+		if part != "" {
+			sb.WriteString(part)
+			sb.WriteByte(' ')
+		}
+	}
+	if source := fields[FieldSource]; source != "" {
+		sb.WriteString(source)
+		if pid := fields["pid"]; pid != "" {
+			sb.WriteString("[" + pid + "]")
+		}
+		sb.WriteString(": ")
+	}
+	sb.WriteString(strings.ReplaceAll(fields[FieldMessage], "\n", " "))
+	return strings.ToValidUTF8(sb.String(), "\uFFFD"), fields
+}
+
+// setJournalFields fills in the fields of both journald formats from the journal fields of an entry
+func setJournalFields(get func(key string) string, fields map[string]string) {
+	if usec, err := strconv.ParseInt(get("__REALTIME_TIMESTAMP"), 10, 64); err == nil {
+		fields[FieldTimestamp] = time.UnixMicro(usec).UTC().Format(time.RFC3339Nano)
+	}
+	fields["priority"] = get("PRIORITY")
+	fields[FieldLevel] = severity(get("PRIORITY"))
+	fields["unit"] = get("_SYSTEMD_UNIT")
+	for _, key := range []string{"SYSLOG_IDENTIFIER", "_SYSTEMD_UNIT", "_COMM"} {
+		if source := get(key); source != "" {
+			fields[FieldSource] = source
+			break
+		}
+	}
+	fields["pid"] = get("_PID")
+	fields["hostname"] = get("_HOSTNAME")
+	fields[FieldMessage] = get("MESSAGE")
+}
+
+func parseJournaldJSON(line string, fields map[string]string) bool {
+	if !strings.HasPrefix(line, "{") || !strings.Contains(line, `"__REALTIME_TIMESTAMP"`) {
+		return false
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return false
+	}
+	setJournalFields(func(key string) string {
+		switch v := entry[key].(type) {
+		case string:
+			return v
+		case []interface{}:
+			// Binary values are exported as an array of bytes
+			b := make([]byte, 0, len(v))
+			for _, n := range v {
+				if n, ok := n.(float64); ok {
+					b = append(b, byte(n))
+				}
+			}
+			return string(b)
+		}
+		return ""
+	}, fields)
+	return true
+}
@@ -0,0 +1,128 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+// binaryField encodes a field the way journalctl -o export writes values that are not plain text
+func binaryField(key, value string) string {
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	return key + "\n" + string(size[:]) + value + "\n"
+}
+
+const journalRecord = "__CURSOR=s=1;i=1\n__REALTIME_TIMESTAMP=1709294400123456\nPRIORITY=3\n" +
+	"_HOSTNAME=web1\nSYSLOG_IDENTIFIER=sshd\n_PID=100\n_SYSTEMD_UNIT=ssh.service\n"
+
+func TestJournalReader(t *testing.T) {
+	stream := journalRecord + "MESSAGE=first\n\n\n" +
+		journalRecord + binaryField("MESSAGE", "two\nlines\x00") + "MESSAGE=ignored\n\n" +
+		"__REALTIME_TIMESTAMP=1\nMESSAGE=no trailing blank line"
+	jr := NewJournalReader(strings.NewReader(stream), 10)
+
+	want := []struct {
+		offset  int64
+		message string
+	}{
+		{10, "first"},
+		{int64(10 + len(journalRecord) + len("MESSAGE=first\n\n\n")), "two\nlines\x00"},
+		{int64(len(stream) + 10 - len("__REALTIME_TIMESTAMP=1\nMESSAGE=no trailing blank line")), "no trailing blank line"},
+	}
+	for i, w := range want {
+		entry, err := jr.Next()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if entry.Offset != w.offset || entry.Fields["MESSAGE"] != w.message {
+			t.Errorf("record %d: got offset %d message %q, want offset %d message %q",
+				i, entry.Offset, entry.Fields["MESSAGE"], w.offset, w.message)
+		}
+	}
+	if _, err := jr.Next(); err != io.EOF {
+		t.Errorf("after the last record: got %v, want io.EOF", err)
+	}
+}
+
+func TestJournalReaderErrors(t *testing.T) {
+	tests := []struct {
+		name, stream string
+		want         error
+	}{
+		{"cut inside binary field", journalRecord + binaryField("MESSAGE", "hello")[:15], io.ErrUnexpectedEOF},
+		{"binary key at end", journalRecord + "MESSAGE", io.ErrUnexpectedEOF},
+		{"not an export stream", "2024-03-01 12:00:00 INFO started\n", nil},
+		{"lower case key", "message=hi\n\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewJournalReader(strings.NewReader(tt.stream), 0).Next()
+			if err == nil {
+				t.Fatal("want an error")
+			}
+			if tt.want != nil && err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJournalEntryParse(t *testing.T) {
+	entry, err := NewJournalReader(strings.NewReader(journalRecord+binaryField("MESSAGE", "failed\nretrying")+"\n"), 0).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, fields := entry.Parse()
+	if want := "2024-03-01T12:00:00.123456Z web1 sshd[100]: failed retrying"; line != want {
+		t.Errorf("line: got %q, want %q", line, want)
+	}
+	want := map[string]string{
+		FieldTimestamp: "2024-03-01T12:00:00.123456Z", FieldLevel: "error", FieldSource: "sshd",
+		FieldMessage: "failed\nretrying", "priority": "3", "unit": "ssh.service", "pid": "100", "hostname": "web1",
+	}
+	if len(fields) != len(want) {
+		t.Errorf("got fields %v, want %v", fields, want)
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("field %s: got %q, want %q", k, fields[k], v)
+		}
+	}
+}
+
+func TestDetectJournald(t *testing.T) {
+	export := []byte(journalRecord + binaryField("MESSAGE", "x") + "\n" + journalRecord + "MESSAGE=y\n\n")
+	json := []byte(`{"__REALTIME_TIMESTAMP":"1709294400123456","PRIORITY":"6","MESSAGE":"a"}` + "\n" +
+		`{"__REALTIME_TIMESTAMP":"1709294400123457","PRIORITY":"6","MESSAGE":[104,105]}` + "\n")
+
+	tests := []struct {
+		name   string
+		sample []byte
+		want   string
+	}{
+		{"export", export, FormatJournald},
+		{"export cut inside a binary field", export[:len(journalRecord)+12], FormatJournald},
+		{"json", json, FormatJournaldJSON},
+		{"key value lines without a timestamp", []byte("A=1\nB=2\n\nA=3\n"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if f := Detect(tt.sample); f != nil {
+				got = f.Name
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	f, _ := Get(FormatJournaldJSON)
+	fields, ok := f.Parse(string(bytes.Split(json, []byte("\n"))[1]))
+	if !ok || fields[FieldMessage] != "hi" {
+		t.Errorf("binary JSON message: got %v", fields)
+	}
+}
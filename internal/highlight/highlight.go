@@ -0,0 +1,207 @@
+package highlight
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"logana/internal/fileutil"
+)
+
+// Rule colors text matching a pattern
+type Rule struct {
+	Name       string `json:"name"`
+	Pattern    string `json:"pattern"`
+	IsRegex    bool   `json:"isRegex"`
+	IgnoreCase bool   `json:"ignoreCase"`
+	Color      string `json:"color"`     // CSS color or palette name, e.g. "red" or "#a855f7"
+	WholeLine  bool   `json:"wholeLine"` // Color the entire line instead of just the match
+	Active     bool   `json:"active"`
+}
+
+// Profile is a named set of highlight rules
+type Profile struct {
+	Name  string `json:"name"`
+	Rules []Rule `json:"rules"`
+}
+
+// ColorSpan is a colored range of Match.Content. Start/End are byte offsets,
+// RuneStart/RuneEnd are code point offsets. Rule is the index of the rule in
+// the profile; when spans overlap, the lower rule index takes precedence.
+type ColorSpan struct {
+	Rule      int    `json:"rule"`
+	Color     string `json:"color"`
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+	RuneStart int    `json:"runeStart"`
+	RuneEnd   int    `json:"runeEnd"`
+}
+
+// Highlighter evaluates a compiled profile. It is safe for concurrent use.
+type Highlighter struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	index     int
+	re        *regexp.Regexp
+	color     string
+	wholeLine bool
+}
+
+// Compile prepares a profile for evaluation. It returns nil for a nil or empty profile.
+func Compile(p *Profile) (*Highlighter, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	h := &Highlighter{}
+	for i, rule := range p.Rules {
+		if !rule.Active || rule.Pattern == "" {
+			continue
+		}
+		pattern := rule.Pattern
+		if !rule.IsRegex {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		if rule.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("highlight rule '%s': invalid regex '%s': %v", rule.Name, rule.Pattern, err)
+		}
+		h.rules = append(h.rules, compiledRule{index: i, re: re, color: rule.Color, wholeLine: rule.WholeLine})
+	}
+
+	if len(h.rules) == 0 {
+		return nil, nil
+	}
+	return h, nil
+}
+
+// Spans returns the colored ranges of text, sorted by start offset
+func (h *Highlighter) Spans(text string) []ColorSpan {
+	var spans []ColorSpan
+	for _, rule := range h.rules {
+		for _, loc := range rule.re.FindAllStringIndex(text, -1) {
+			start, end := loc[0], loc[1]
+			if rule.wholeLine {
+				start, end = lineBounds(text, start, end)
+			}
+			if start == end {
+				continue
+			}
+			spans = append(spans, ColorSpan{Rule: rule.index, Color: rule.color, Start: start, End: end})
+		}
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].Start != spans[j].Start {
+			return spans[i].Start < spans[j].Start
+		}
+		return spans[i].Rule < spans[j].Rule
+	})
+
+	// Convert byte offsets to rune offsets in a single forward pass
+	pos, runes := 0, 0
+	for i := range spans {
+		runes += utf8.RuneCountInString(text[pos:spans[i].Start])
+		pos = spans[i].Start
+		spans[i].RuneStart = runes
+		spans[i].RuneEnd = runes + utf8.RuneCountInString(text[spans[i].Start:spans[i].End])
+	}
+	return dedupe(spans)
+}
+
+// lineBounds widens [start, end) to the full lines it touches
+func lineBounds(text string, start, end int) (int, int) {
+	lineStart := strings.LastIndexByte(text[:start], '\n') + 1
+	lineEnd := len(text)
+	if i := strings.IndexByte(text[end:], '\n'); i >= 0 {
+		lineEnd = end + i
+	}
+	return lineStart, lineEnd
+}
+
+// dedupe drops repeated whole-line spans produced by several matches on one line
+func dedupe(spans []ColorSpan) []ColorSpan {
+	out := spans[:0]
+	for i, s := range spans {
+		if i > 0 && s == spans[i-1] {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Store persists highlight profiles
+type Store struct {
+	configPath string
+}
+
+func NewStore(appConfigDir string) *Store {
+	configPath := filepath.Join(appConfigDir, "highlight_profiles.json")
+	return &Store{
+		configPath: configPath,
+	}
+}
+
+// SaveProfiles saves all highlight profiles to a local file
+func (s *Store) SaveProfiles(profiles []Profile) error {
+	dir := filepath.Dir(s.configPath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteAtomic(s.configPath, data, 0o644)
+}
+
+// LoadProfiles loads all highlight profiles from the local file.
+// A default profile is returned when none have been saved yet.
+func (s *Store) LoadProfiles() ([]Profile, error) {
+	if _, err := os.Stat(s.configPath); os.IsNotExist(err) {
+		return []Profile{DefaultProfile()}, nil
+	}
+
+	data, err := os.ReadFile(s.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+// DefaultProfile colors the common log levels
+func DefaultProfile() Profile {
+	return Profile{
+		Name: "Log levels",
+		Rules: []Rule{
+			{Name: "Fatal", Pattern: `\b(FATAL|PANIC|CRITICAL)\b`, IsRegex: true, Color: "red", WholeLine: true, Active: true},
+			{Name: "Error", Pattern: `\bERROR\b`, IsRegex: true, Color: "red", Active: true},
+			{Name: "Warning", Pattern: `\bWARN(ING)?\b`, IsRegex: true, Color: "yellow", Active: true},
+			{Name: "Debug", Pattern: `\b(DEBUG|TRACE)\b`, IsRegex: true, Color: "gray", Active: true},
+		},
+	}
+}
@@ -0,0 +1,122 @@
+package highlight
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// render formats spans as "rule:color[start,end)=text", with rune offsets checked against byte offsets
+func render(t *testing.T, text string, spans []ColorSpan) string {
+	t.Helper()
+	var out []string
+	for _, s := range spans {
+		if len([]rune(text[:s.Start])) != s.RuneStart || len([]rune(text[:s.End])) != s.RuneEnd {
+			t.Errorf("span %+v: rune offsets do not match the byte offsets", s)
+		}
+		out = append(out, fmt.Sprintf("%d:%s=%s", s.Rule, s.Color, text[s.Start:s.End]))
+	}
+	return strings.Join(out, " ")
+}
+
+func TestSpans(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		text  string
+		want  string
+	}{
+		{"plain text is quoted", []Rule{{Pattern: "a.b", Color: "red", Active: true}}, "a.b axb", "0:red=a.b"},
+		{"regex", []Rule{{Pattern: `\d+`, IsRegex: true, Color: "blue", Active: true}}, "id 12 and 345", "0:blue=12 0:blue=345"},
+		{"ignore case", []Rule{{Pattern: "error", IgnoreCase: true, Color: "red", Active: true}}, "Error ERROR", "0:red=Error 0:red=ERROR"},
+		{"whole line", []Rule{{Pattern: "FATAL", Color: "red", WholeLine: true, Active: true}}, "ok\nthe FATAL one FATAL\nnext", "0:red=the FATAL one FATAL"},
+		{"whole line at the edges", []Rule{{Pattern: "x", Color: "red", WholeLine: true, Active: true}}, "x first\nlast x", "0:red=x first 0:red=last x"},
+		{"sorted by start, then rule", []Rule{
+			{Pattern: "world", Color: "green", Active: true},
+			{Pattern: "hello world", Color: "red", Active: true},
+			{Pattern: "hello", Color: "blue", Active: true},
+		}, "hello world", "1:red=hello world 2:blue=hello 0:green=world"},
+		{"multi-byte offsets", []Rule{{Pattern: "错误", Color: "red", Active: true}, {Pattern: "ü", Color: "blue", Active: true}}, "über 错误 ü", "1:blue=ü 0:red=错误 1:blue=ü"},
+		{"empty matches dropped", []Rule{{Pattern: `x*`, IsRegex: true, Color: "red", Active: true}}, "axxb", "0:red=xx"},
+		{"inactive and empty rules skipped", []Rule{
+			{Pattern: "a", Color: "red", Active: false},
+			{Pattern: "", Color: "blue", Active: true},
+			{Pattern: "b", Color: "green", Active: true},
+		}, "ab", "2:green=b"},
+		{"no match", []Rule{{Pattern: "z", Color: "red", Active: true}}, "ab", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := Compile(&Profile{Name: tt.name, Rules: tt.rules})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := render(t, tt.text, h.Spans(tt.text)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, p := range []*Profile{nil, {Name: "empty"}, {Name: "off", Rules: []Rule{{Pattern: "x"}}}} {
+		if h, err := Compile(p); h != nil || err != nil {
+			t.Errorf("%+v: got %v, %v; want no highlighter", p, h, err)
+		}
+	}
+
+	_, err := Compile(&Profile{Rules: []Rule{{Name: "broken", Pattern: "(", IsRegex: true, Active: true}}})
+	if err == nil || !strings.Contains(err.Error(), "highlight rule 'broken'") {
+		t.Errorf("got %v", err)
+	}
+
+	h, err := Compile(&Profile{Rules: DefaultProfile().Rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "INFO ok\nPANIC now\nWARNING low disk, ERROR soon\nDEBUGGING"
+	if got := render(t, text, h.Spans(text)); got != "0:red=PANIC now 2:yellow=WARNING 1:red=ERROR" {
+		t.Errorf("default profile: got %q", got)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "config")
+	s := NewStore(dir)
+
+	got, err := s.LoadProfiles()
+	if err != nil || !reflect.DeepEqual(got, []Profile{DefaultProfile()}) {
+		t.Fatalf("nothing saved: got %+v, %v; want the default profile", got, err)
+	}
+
+	profiles := []Profile{{Name: "mine", Rules: []Rule{{Name: "ids", Pattern: `id=\d+`, IsRegex: true, Color: "#a855f7", Active: true}}}}
+	for i := 0; i < 2; i++ {
+		if err := s.SaveProfiles(profiles); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err = s.LoadProfiles(); err != nil || !reflect.DeepEqual(got, profiles) {
+		t.Errorf("got %+v, %v; want %+v", got, err, profiles)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("config dir has %d entries, want only highlight_profiles.json", len(entries))
+	}
+
+	// Saving no profiles keeps them gone rather than bringing back the default
+	if err := s.SaveProfiles([]Profile{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = s.LoadProfiles(); err != nil || len(got) != 0 {
+		t.Errorf("after deleting every profile: got %+v, %v", got, err)
+	}
+
+	if err := os.WriteFile(s.configPath, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoadProfiles(); err == nil {
+		t.Error("a corrupt file loaded without error")
+	}
+}
@@ -0,0 +1,238 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"logana/internal/fileutil"
+	"logana/internal/scanner"
+)
+
+// maxRecent is how many recent searches are kept
+const maxRecent = 50
+
+// Entry is a recorded or named search
+type Entry struct {
+	ID          string                `json:"id"`
+	Name        string                `json:"name,omitempty"` // Empty for recent searches
+	Options     scanner.SearchOptions `json:"options"`
+	FilePath    string                `json:"filePath"`
+	Timestamp   time.Time             `json:"timestamp"`
+	ResultCount int64                 `json:"resultCount"`
+}
+
+// store is the on-disk layout of the history file
+type store struct {
+	Recent []Entry `json:"recent"`
+	Saved  []Entry `json:"saved"`
+}
+
+// History persists recent and named saved searches
+type History struct {
+	configPath string
+	mu         sync.Mutex
+}
+
+func NewHistory(appConfigDir string) *History {
+	configPath := filepath.Join(appConfigDir, "search_history.json")
+	return &History{
+		configPath: configPath,
+	}
+}
+
+// Record adds a finished search to the front of the recent list.
+// An older entry with identical options is replaced.
+func (h *History) Record(opts scanner.SearchOptions, resultCount int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, err := h.load()
+	if err != nil {
+		return err
+	}
+
+	recent := []Entry{newEntry("", opts, resultCount)}
+	for _, e := range s.Recent {
+		if reflect.DeepEqual(e.Options, opts) {
+			continue
+		}
+		recent = append(recent, e)
+	}
+	if len(recent) > maxRecent {
+		recent = recent[:maxRecent]
+	}
+	s.Recent = recent
+
+	return h.save(s)
+}
+
+// Recent returns recent searches, newest first
+func (h *History) Recent() ([]Entry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+	return s.Recent, nil
+}
+
+// Saved returns named saved searches
+func (h *History) Saved() ([]Entry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, err := h.load()
+	if err != nil {
+		return nil, err
+	}
+	return s.Saved, nil
+}
+
+// Save stores a named search. A saved search with the same name is overwritten.
+func (h *History) Save(name string, opts scanner.SearchOptions, resultCount int64) (Entry, error) {
+	if name == "" {
+		return Entry{}, fmt.Errorf("saved search name is required")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, err := h.load()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := newEntry(name, opts, resultCount)
+	replaced := false
+	for i, e := range s.Saved {
+		if e.Name == name {
+			entry.ID = e.ID
+			s.Saved[i] = entry
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		s.Saved = append(s.Saved, entry)
+	}
+
+	return entry, h.save(s)
+}
+
+// Get looks up a recent or saved search by ID
+func (h *History) Get(id string) (Entry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, err := h.load()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, e := range append(s.Saved, s.Recent...) {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return Entry{}, fmt.Errorf("search '%s' not found", id)
+}
+
+// Touch refreshes the timestamp and result count of a saved search after it was re-run
+func (h *History) Touch(id string, resultCount int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, err := h.load()
+	if err != nil {
+		return err
+	}
+	for i := range s.Saved {
+		if s.Saved[i].ID == id {
+			s.Saved[i].Timestamp = time.Now()
+			s.Saved[i].ResultCount = resultCount
+			return h.save(s)
+		}
+	}
+	return nil
+}
+
+// Delete removes a recent or saved search by ID
+func (h *History) Delete(id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, err := h.load()
+	if err != nil {
+		return err
+	}
+	s.Recent = without(s.Recent, id)
+	s.Saved = without(s.Saved, id)
+	return h.save(s)
+}
+
+func newEntry(name string, opts scanner.SearchOptions, resultCount int64) Entry {
+	now := time.Now()
+	return Entry{
+		ID:          strconv.FormatInt(now.UnixNano(), 36),
+		Name:        name,
+		Options:     opts,
+		FilePath:    opts.FilePath,
+		Timestamp:   now,
+		ResultCount: resultCount,
+	}
+}
+
+func without(entries []Entry, id string) []Entry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.ID != id {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func (h *History) load() (store, error) {
+	s := store{Recent: []Entry{}, Saved: []Entry{}}
+	if _, err := os.Stat(h.configPath); os.IsNotExist(err) {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(h.configPath)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, err
+	}
+	if s.Recent == nil {
+		s.Recent = []Entry{}
+	}
+	if s.Saved == nil {
+		s.Saved = []Entry{}
+	}
+	return s, nil
+}
+
+func (h *History) save(s store) error {
+	dir := filepath.Dir(h.configPath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteAtomic(h.configPath, data, 0o644)
+}
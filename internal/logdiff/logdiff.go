@@ -0,0 +1,370 @@
+package logdiff
+
+import (
+	"bufio"
+	"context"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+
+	"logana/internal/replacer"
+)
+
+const (
+	defaultWindow  = 2000 // Lines of lookahead used to resynchronize after a change
+	defaultContext = 3
+	progressEvery  = 8 * 1024 * 1024
+	maxHunkLines   = 5000 // Longer hunks are sent in parts so they are not held in memory
+)
+
+// Options defines the parameters of a log diff
+type Options struct {
+	LeftPath     string          `json:"leftPath"`
+	RightPath    string          `json:"rightPath"`
+	Rules        []replacer.Rule `json:"rules"`        // User normalization rules, applied after the built-in masks
+	NoBuiltin    bool            `json:"noBuiltin"`    // Skip the built-in volatile field masks
+	Window       int             `json:"window"`       // Lookahead lines for resynchronization
+	Context      int             `json:"context"`      // Unchanged lines shown around each hunk; 0 for the default, negative for none
+	IgnoreSpaces bool            `json:"ignoreSpaces"` // Collapse runs of whitespace before comparing
+}
+
+// DiffLine is one line of a hunk. Op is " " for unchanged, "-" for removed
+// from the left file and "+" for added in the right file.
+type DiffLine struct {
+	Op        string `json:"op"`
+	Text      string `json:"text"`
+	LeftLine  int    `json:"leftLine,omitempty"`
+	RightLine int    `json:"rightLine,omitempty"`
+}
+
+// Hunk is a group of changes with surrounding context. A hunk longer than
+// maxHunkLines is split; every part but the last has Continued set.
+type Hunk struct {
+	LeftStart  int        `json:"leftStart"`
+	LeftCount  int        `json:"leftCount"`
+	RightStart int        `json:"rightStart"`
+	RightCount int        `json:"rightCount"`
+	Lines      []DiffLine `json:"lines"`
+	Continued  bool       `json:"continued,omitempty"`
+}
+
+// Summary describes the outcome of a diff
+type Summary struct {
+	LeftLines  int `json:"leftLines"`
+	RightLines int `json:"rightLines"`
+	Removed    int `json:"removed"`
+	Added      int `json:"added"`
+	Hunks      int `json:"hunks"`
+}
+
+// line is a line read from one side together with its normalized hash
+type line struct {
+	number int
+	text   string
+	hash   uint64
+}
+
+// normalizer masks volatile tokens so only meaningful differences remain
+type normalizer struct {
+	rules        *replacer.Compiled
+	ignoreSpaces bool
+}
+
+func newNormalizer(opts Options) (*normalizer, error) {
+	var rules []replacer.Rule
+	if !opts.NoBuiltin {
+		rules = append(rules, replacer.VolatileRules()...)
+	}
+	rules = append(rules, opts.Rules...)
+
+	compiled, err := replacer.Compile(rules)
+	if err != nil {
+		return nil, err
+	}
+	return &normalizer{rules: compiled, ignoreSpaces: opts.IgnoreSpaces}, nil
+}
+
+func (n *normalizer) hash(text string) uint64 {
+	text = n.rules.Replace(text)
+	if n.ignoreSpaces {
+		text = strings.Join(strings.Fields(text), " ")
+	}
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	return h.Sum64()
+}
+
+// side streams the lines of one file into a bounded lookahead queue
+type side struct {
+	reader *bufio.Reader
+	queue  []line
+	read   int   // Lines read so far
+	bytes  int64 // Bytes read so far
+	eof    bool
+}
+
+func (s *side) fill(n *normalizer, window int) error {
+	for !s.eof && len(s.queue) < window {
+		text, err := s.reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF {
+			s.eof = true
+			if text == "" {
+				break
+			}
+		}
+		s.bytes += int64(len(text))
+		text = strings.TrimRight(text, "\r\n")
+		s.read++
+		s.queue = append(s.queue, line{number: s.read, text: text, hash: n.hash(text)})
+	}
+	return nil
+}
+
+// Diff compares two log files line by line after normalization and sends
+// hunks as they are found. Memory is bounded by the lookahead window and
+// maxHunkLines, so inputs of any size can be compared; changes longer than
+// the window are reported as a replaced block, sent in parts.
+func Diff(ctx context.Context, opts Options, progress chan<- float64, hunks chan<- Hunk) (Summary, error) {
+	var summary Summary
+
+	norm, err := newNormalizer(opts)
+	if err != nil {
+		return summary, err
+	}
+
+	left, err := os.Open(opts.LeftPath)
+	if err != nil {
+		return summary, err
+	}
+	defer left.Close()
+
+	right, err := os.Open(opts.RightPath)
+	if err != nil {
+		return summary, err
+	}
+	defer right.Close()
+
+	var totalSize int64
+	for _, f := range []*os.File{left, right} {
+		stat, err := f.Stat()
+		if err != nil {
+			return summary, err
+		}
+		totalSize += stat.Size()
+	}
+
+	window := opts.Window
+	if window <= 0 {
+		window = defaultWindow
+	}
+	contextLines := opts.Context
+	if contextLines < 0 {
+		contextLines = 0
+	} else if contextLines == 0 {
+		contextLines = defaultContext
+	}
+
+	l := &side{reader: bufio.NewReaderSize(left, 1024*1024)}
+	r := &side{reader: bufio.NewReaderSize(right, 1024*1024)}
+	b := &hunkBuilder{context: contextLines, hunks: hunks, summary: &summary}
+
+	var lastReport int64
+	for {
+		select {
+		case <-ctx.Done():
+			return summary, ctx.Err()
+		default:
+		}
+
+		if err := l.fill(norm, window); err != nil {
+			return summary, err
+		}
+		if err := r.fill(norm, window); err != nil {
+			return summary, err
+		}
+
+		if progress != nil && totalSize > 0 && l.bytes+r.bytes-lastReport >= progressEvery {
+			lastReport = l.bytes + r.bytes
+			progress <- float64(lastReport) / float64(totalSize) * 100
+		}
+
+		if len(l.queue) == 0 && len(r.queue) == 0 {
+			break
+		}
+
+		if len(l.queue) > 0 && len(r.queue) > 0 && l.queue[0].hash == r.queue[0].hash {
+			b.equal(l.queue[0], r.queue[0])
+			l.queue = l.queue[1:]
+			r.queue = r.queue[1:]
+			continue
+		}
+
+		i, j := resync(l.queue, r.queue)
+		for _, ln := range l.queue[:i] {
+			b.removed(ln)
+		}
+		for _, ln := range r.queue[:j] {
+			b.added(ln)
+		}
+		l.queue = l.queue[i:]
+		r.queue = r.queue[j:]
+	}
+
+	b.flush()
+	summary.LeftLines = l.read
+	summary.RightLines = r.read
+	if progress != nil {
+		progress <- 100
+	}
+	return summary, nil
+}
+
+// resync finds the cheapest point (fewest skipped lines) where both queues
+// line up again. A candidate followed by a second matching line is preferred
+// so common lines like blanks do not cause spurious alignment. If nothing
+// lines up within the window, both windows are consumed entirely.
+func resync(left, right []line) (int, int) {
+	firstInRight := make(map[uint64]int, len(right))
+	for j := len(right) - 1; j >= 0; j-- {
+		firstInRight[right[j].hash] = j
+	}
+
+	bestI, bestJ := -1, -1
+	weakI, weakJ := -1, -1
+	for i, ln := range left {
+		if bestI >= 0 && i >= bestI+bestJ {
+			break // No later candidate can be cheaper
+		}
+		j, ok := firstInRight[ln.hash]
+		if !ok {
+			continue
+		}
+		anchored := i+1 >= len(left) || j+1 >= len(right) || left[i+1].hash == right[j+1].hash
+		if anchored && (bestI < 0 || i+j < bestI+bestJ) {
+			bestI, bestJ = i, j
+		}
+		if weakI < 0 || i+j < weakI+weakJ {
+			weakI, weakJ = i, j
+		}
+	}
+
+	switch {
+	case bestI >= 0:
+		return bestI, bestJ
+	case weakI >= 0:
+		return weakI, weakJ
+	}
+	return len(left), len(right)
+}
+
+// hunkBuilder groups changes into hunks with surrounding context. As in a
+// unified diff, changes separated by at most twice the context are merged
+// into one hunk.
+type hunkBuilder struct {
+	context   int
+	hunks     chan<- Hunk
+	summary   *Summary
+	before    []DiffLine // Unchanged lines that may lead the next hunk
+	current   *Hunk
+	trailing  int // Unchanged lines appended since the last change
+	nextLeft  int // Line numbers of the next unchanged line on each side
+	nextRight int
+}
+
+func (b *hunkBuilder) equal(l, r line) {
+	dl := DiffLine{Op: " ", Text: r.text, LeftLine: l.number, RightLine: r.number}
+	b.nextLeft, b.nextRight = l.number+1, r.number+1
+
+	if b.current != nil {
+		b.current.Lines = append(b.current.Lines, dl)
+		b.current.LeftCount++
+		b.current.RightCount++
+		b.trailing++
+		if b.trailing <= 2*b.context {
+			return
+		}
+		// Too far from the next change to merge; flush keeps the trailing context
+		// and passes the rest on as leading context
+		b.flush()
+		return
+	}
+
+	if b.context > 0 {
+		b.before = append(b.before, dl)
+		if len(b.before) > b.context {
+			b.before = b.before[1:]
+		}
+	}
+}
+
+func (b *hunkBuilder) open() {
+	if b.current == nil {
+		b.current = &Hunk{LeftStart: b.nextLeft, RightStart: b.nextRight}
+		if len(b.before) > 0 {
+			b.current.LeftStart = b.before[0].LeftLine
+			b.current.RightStart = b.before[0].RightLine
+		}
+		b.current.Lines = append(b.current.Lines, b.before...)
+		b.current.LeftCount = len(b.before)
+		b.current.RightCount = len(b.before)
+		b.before = nil
+	}
+	b.trailing = 0
+}
+
+func (b *hunkBuilder) removed(l line) {
+	if b.nextLeft == 0 {
+		b.nextLeft, b.nextRight = 1, 1
+	}
+	b.open()
+	b.split()
+	b.current.Lines = append(b.current.Lines, DiffLine{Op: "-", Text: l.text, LeftLine: l.number})
+	b.current.LeftCount++
+	b.summary.Removed++
+}
+
+func (b *hunkBuilder) added(r line) {
+	if b.nextLeft == 0 {
+		b.nextLeft, b.nextRight = 1, 1
+	}
+	b.open()
+	b.split()
+	b.current.Lines = append(b.current.Lines, DiffLine{Op: "+", Text: r.text, RightLine: r.number})
+	b.current.RightCount++
+	b.summary.Added++
+}
+
+// split sends a hunk that reached maxHunkLines before another change is
+// added to it, and continues in a new hunk
+func (b *hunkBuilder) split() {
+	if len(b.current.Lines) < maxHunkLines {
+		return
+	}
+	part := b.current
+	part.Continued = true
+	b.current = &Hunk{LeftStart: part.LeftStart + part.LeftCount, RightStart: part.RightStart + part.RightCount}
+	b.hunks <- *part
+	b.summary.Hunks++
+}
+
+func (b *hunkBuilder) flush() {
+	if b.current == nil {
+		return
+	}
+	// Unchanged lines past the context are left out and may lead the next hunk
+	if extra := b.trailing - b.context; extra > 0 {
+		cut := len(b.current.Lines) - extra
+		rest := b.current.Lines[cut:]
+		b.current.Lines = b.current.Lines[:cut]
+		b.current.LeftCount -= extra
+		b.current.RightCount -= extra
+		b.before = append([]DiffLine(nil), rest[max(0, len(rest)-b.context):]...)
+	}
+	b.hunks <- *b.current
+	b.summary.Hunks++
+	b.current = nil
+}
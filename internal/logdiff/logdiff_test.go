@@ -0,0 +1,198 @@
+package logdiff
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// numbered returns n lines "<prefix> <i>", each ending in a newline
+func numbered(prefix string, from, n int) string {
+	var sb strings.Builder
+	for i := from; i < from+n; i++ {
+		fmt.Fprintf(&sb, "%s %d\n", prefix, i)
+	}
+	return sb.String()
+}
+
+// diffTexts writes both sides to files and collects the hunks of their diff
+func diffTexts(t *testing.T, left, right string, opts Options) ([]Hunk, Summary) {
+	t.Helper()
+	dir := t.TempDir()
+	opts.LeftPath, opts.RightPath = filepath.Join(dir, "left.log"), filepath.Join(dir, "right.log")
+	if err := os.WriteFile(opts.LeftPath, []byte(left), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(opts.RightPath, []byte(right), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts.NoBuiltin = true
+
+	hunks := make(chan Hunk)
+	var got []Hunk
+	done := make(chan struct{})
+	go func() {
+		for h := range hunks {
+			got = append(got, h)
+		}
+		close(done)
+	}()
+	summary, err := Diff(context.Background(), opts, nil, hunks)
+	close(hunks)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got, summary
+}
+
+// render formats hunks like a unified diff body, one hunk per entry
+func render(hunks []Hunk) []string {
+	out := make([]string, len(hunks))
+	for i, h := range hunks {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@", h.LeftStart, h.LeftCount, h.RightStart, h.RightCount)
+		for _, dl := range h.Lines {
+			sb.WriteString("|" + dl.Op + dl.Text)
+		}
+		out[i] = sb.String()
+	}
+	return out
+}
+
+func TestDiffHunks(t *testing.T) {
+	base := numbered("line", 1, 20)
+	replace := func(text string, pairs ...string) string { return strings.NewReplacer(pairs...).Replace(text) }
+
+	tests := []struct {
+		name  string
+		left  string
+		right string
+		opts  Options
+		want  []string
+	}{
+		{"identical", base, base, Options{}, nil},
+		{"one change", base, replace(base, "line 10\n", "LINE 10\n"), Options{Context: 1},
+			[]string{"@@ -9,3 +9,3 @@| line 9|-line 10|+LINE 10| line 11"}},
+		{"changes 2*context apart merge", base, replace(base, "line 5\n", "x\n", "line 8\n", "y\n"), Options{Context: 1},
+			[]string{"@@ -4,6 +4,6 @@| line 4|-line 5|+x| line 6| line 7|-line 8|+y| line 9"}},
+		{"changes further apart stay separate", base, replace(base, "line 5\n", "x\n", "line 9\n", "y\n"), Options{Context: 1},
+			[]string{"@@ -4,3 +4,3 @@| line 4|-line 5|+x| line 6", "@@ -8,3 +8,3 @@| line 8|-line 9|+y| line 10"}},
+		{"no context", base, replace(base, "line 5\n", "x\n", "line 6\n", "y\n"), Options{Context: -1},
+			[]string{"@@ -5,2 +5,2 @@|-line 5|-line 6|+x|+y"}},
+		{"insertion at the start", base, "new\n" + base, Options{Context: 2},
+			[]string{"@@ -1,2 +1,3 @@|+new| line 1| line 2"}},
+		{"removal at the end", base, numbered("line", 1, 19), Options{Context: 2},
+			[]string{"@@ -18,3 +18,2 @@| line 18| line 19|-line 20"}},
+		{"whitespace ignored", "a  b\nc\n", "a b\nc\n", Options{IgnoreSpaces: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks, summary := diffTexts(t, tt.left, tt.right, tt.opts)
+			got := render(hunks)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if summary.Hunks != len(hunks) {
+				t.Errorf("summary counts %d hunks, got %d", summary.Hunks, len(hunks))
+			}
+		})
+	}
+}
+
+func TestDiffNormalizes(t *testing.T) {
+	left := "2024-03-01T10:00:00Z request 7f3a9c2e-1b4d-4e8a-9c3f-2a1b3c4d5e6f done\nfailed\n"
+	right := "2024-03-02T11:30:00Z request 0b1c2d3e-4f50-4617-8293-a4b5c6d7e8f9 done\nfailed\n"
+
+	dir := t.TempDir()
+	opts := Options{LeftPath: filepath.Join(dir, "left.log"), RightPath: filepath.Join(dir, "right.log")}
+	if err := os.WriteFile(opts.LeftPath, []byte(left), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(opts.RightPath, []byte(right), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	hunks := make(chan Hunk, 10)
+	summary, err := Diff(context.Background(), opts, nil, hunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Hunks != 0 || summary.LeftLines != 2 || summary.RightLines != 2 {
+		t.Errorf("volatile fields were not masked: %+v", summary)
+	}
+}
+
+func TestResync(t *testing.T) {
+	lines := func(hashes ...uint64) []line {
+		out := make([]line, len(hashes))
+		for i, h := range hashes {
+			out[i] = line{number: i + 1, hash: h}
+		}
+		return out
+	}
+
+	tests := []struct {
+		name         string
+		left, right  []line
+		wantI, wantJ int
+	}{
+		{"insertion", lines(1, 2, 3), lines(9, 1, 2, 3), 0, 1},
+		{"removal", lines(9, 9, 1, 2), lines(1, 2), 2, 0},
+		{"replacement", lines(8, 1, 2), lines(9, 1, 2), 1, 1},
+		{"cheapest point wins", lines(5, 1, 2, 3), lines(1, 2, 3, 5), 1, 0},
+		{"anchored match preferred", lines(9, 0, 1, 2), lines(0, 7, 0, 1, 2), 2, 3},
+		{"lone match used when nothing is anchored", lines(9, 0, 8), lines(0, 7), 1, 0},
+		{"nothing lines up", lines(1, 2), lines(3, 4, 5), 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if i, j := resync(tt.left, tt.right); i != tt.wantI || j != tt.wantJ {
+				t.Errorf("got (%d, %d), want (%d, %d)", i, j, tt.wantI, tt.wantJ)
+			}
+		})
+	}
+}
+
+func TestDiffResyncsAfterChanges(t *testing.T) {
+	left := numbered("line", 1, 100)
+	right := numbered("line", 1, 30) + numbered("new", 1, 5) + numbered("line", 41, 60)
+
+	hunks, summary := diffTexts(t, left, right, Options{Context: -1, Window: 50})
+	want := []string{"@@ -31,10 +31,5 @@|-line 31|-line 32|-line 33|-line 34|-line 35|-line 36|-line 37|-line 38|-line 39|-line 40|+new 1|+new 2|+new 3|+new 4|+new 5"}
+	if got := render(hunks); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %v", got)
+	}
+	if summary.Removed != 10 || summary.Added != 5 || summary.LeftLines != 100 || summary.RightLines != 95 {
+		t.Errorf("got %+v", summary)
+	}
+}
+
+func TestDiffLargeInputsStream(t *testing.T) {
+	const n = 30000
+	hunks, summary := diffTexts(t, numbered("left", 1, n), numbered("right", 1, n), Options{})
+
+	if len(hunks) < 2*n/maxHunkLines {
+		t.Fatalf("got %d hunks; a long change must be sent in parts", len(hunks))
+	}
+	left, right := 1, 1
+	for i, h := range hunks {
+		if len(h.Lines) > maxHunkLines {
+			t.Errorf("hunk %d has %d lines, more than %d", i, len(h.Lines), maxHunkLines)
+		}
+		if h.Continued != (i < len(hunks)-1) {
+			t.Errorf("hunk %d: continued is %v", i, h.Continued)
+		}
+		// The parts follow on from each other
+		if h.LeftStart != left || h.RightStart != right {
+			t.Errorf("hunk %d starts at -%d +%d, want -%d +%d", i, h.LeftStart, h.RightStart, left, right)
+		}
+		left, right = h.LeftStart+h.LeftCount, h.RightStart+h.RightCount
+	}
+	if left != n+1 || right != n+1 || summary.Removed != n || summary.Added != n {
+		t.Errorf("hunks end at -%d +%d, summary %+v", left, right, summary)
+	}
+}
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"logana/internal/fileutil"
+	"logana/internal/replacer"
+)
+
+// Stage types
+const (
+	StageInclude   = "include"   // Keep lines matching the query
+	StageExclude   = "exclude"   // Drop lines matching the query
+	StageTransform = "transform" // Rewrite the line with replacer rules
+	StageExtract   = "extract"   // Pull fields out of the line with a regex
+)
+
+// Stage is one step of a filter pipeline
+type Stage struct {
+	Type       string          `json:"type"`
+	Query      string          `json:"query,omitempty"` // include/exclude: same syntax as the search box
+	IsRegex    bool            `json:"isRegex,omitempty"`
+	IgnoreCase bool            `json:"ignoreCase,omitempty"`
+	Logic      string          `json:"logic,omitempty"`   // "AND" or "OR"
+	Rules      []replacer.Rule `json:"rules,omitempty"`   // transform
+	Pattern    string          `json:"pattern,omitempty"` // extract: named groups become fields
+	Field      string          `json:"field,omitempty"`   // extract: field name for a pattern without named groups
+	Disabled   bool            `json:"disabled,omitempty"`
+}
+
+// Pipeline defines a named chain of stages run on every line in order
+type Pipeline struct {
+	Name   string  `json:"name"`
+	Stages []Stage `json:"stages"`
+}
+
+// Store persists pipelines next to the regex rule sets
+type Store struct {
+	configPath string
+}
+
+func NewStore(appConfigDir string) *Store {
+	configPath := filepath.Join(appConfigDir, "pipelines.json")
+	return &Store{
+		configPath: configPath,
+	}
+}
+
+// SavePipelines saves all pipelines to a local file
+func (s *Store) SavePipelines(pipelines []Pipeline) error {
+	dir := filepath.Dir(s.configPath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(pipelines, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteAtomic(s.configPath, data, 0o644)
+}
+
+// LoadPipelines loads all pipelines from the local file
+func (s *Store) LoadPipelines() ([]Pipeline, error) {
+	if _, err := os.Stat(s.configPath); os.IsNotExist(err) {
+		return []Pipeline{}, nil
+	}
+
+	data, err := os.ReadFile(s.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var pipelines []Pipeline
+	if err := json.Unmarshal(data, &pipelines); err != nil {
+		return nil, err
+	}
+
+	return pipelines, nil
+}
@@ -0,0 +1,45 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"logana/internal/replacer"
+)
+
+func TestStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "config")
+	s := NewStore(dir)
+
+	got, err := s.LoadPipelines()
+	if err != nil || got == nil || len(got) != 0 {
+		t.Fatalf("nothing saved: got %v, %v; want an empty list", got, err)
+	}
+
+	pipelines := []Pipeline{{Name: "errors", Stages: []Stage{
+		{Type: StageInclude, Query: "ERROR", IgnoreCase: true},
+		{Type: StageExclude, Query: "health|ping", IsRegex: true, Logic: "OR", Disabled: true},
+		{Type: StageTransform, Rules: []replacer.Rule{{Name: "n", Pattern: `\d+`, Replacement: "<N>", Active: true}}},
+		{Type: StageExtract, Pattern: `user=(\w+)`, Field: "user"},
+	}}, {Name: "empty", Stages: []Stage{}}}
+	for i := 0; i < 2; i++ {
+		if err := s.SavePipelines(pipelines); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err = s.LoadPipelines(); err != nil || !reflect.DeepEqual(got, pipelines) {
+		t.Errorf("got %+v, %v; want %+v", got, err, pipelines)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("config dir has %d entries, want only pipelines.json", len(entries))
+	}
+
+	if err := os.WriteFile(s.configPath, []byte("[{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoadPipelines(); err == nil {
+		t.Error("a corrupt file loaded without error")
+	}
+}
@@ -0,0 +1,407 @@
+package replacer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+)
+
+// maxCached bounds the regexp cache; it is emptied when full
+const maxCached = 512
+
+// cache holds compiled patterns keyed by the pattern with its flag prefix,
+// so re-running an unchanged rule set (every keystroke in the editor) costs no compilation
+var cache = struct {
+	sync.Mutex
+	res map[string]*regexp.Regexp
+}{res: make(map[string]*regexp.Regexp)}
+
+// compileCached compiles a pattern or returns the cached result
+func compileCached(expr string) (*regexp.Regexp, error) {
+	cache.Lock()
+	re, ok := cache.res[expr]
+	cache.Unlock()
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.Lock()
+	if len(cache.res) >= maxCached {
+		cache.res = make(map[string]*regexp.Regexp)
+	}
+	cache.res[expr] = re
+	cache.Unlock()
+	return re, nil
+}
+
+// CompileError describes why one rule of a set failed to compile
+type CompileError struct {
+	Index    int    `json:"index"` // Position of the rule in the set
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"`
+	Field    string `json:"field,omitempty"` // Which part of the rule is at fault; empty for the pattern
+	Position int    `json:"position"`        // Byte offset of the offending expression in that part, or -1
+	Message  string `json:"message"`
+}
+
+// Parts of a rule other than its pattern that can fail to compile
+const (
+	FieldReplacement  = "replacement"
+	FieldCheck        = "check"
+	FieldGuard        = "guard"
+	FieldScope        = "scope"
+	FieldScopePattern = "scopePattern"
+)
+
+var fieldLabels = map[string]string{
+	FieldReplacement:  "replacement template",
+	FieldCheck:        "check",
+	FieldGuard:        "guard pattern",
+	FieldScope:        "scope",
+	FieldScopePattern: "scope pattern",
+}
+
+func (e *CompileError) Error() string {
+	name := e.Name
+	if name == "" {
+		name = fmt.Sprintf("#%d", e.Index+1)
+	}
+	if label, ok := fieldLabels[e.Field]; ok {
+		return fmt.Sprintf("rule %s: invalid %s: %s", name, label, e.Message)
+	}
+	return fmt.Sprintf("rule %s: invalid regex pattern '%s': %s", name, e.Pattern, e.Message)
+}
+
+// CompileErrors lists every rule of a set that failed to compile
+type CompileErrors []*CompileError
+
+func (errs CompileErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// newCompileError locates the offending part of expr, the rule's pattern or
+// the regex in field, when the regexp parser names it
+func newCompileError(index int, rule Rule, field, expr string, err error) *CompileError {
+	ce := &CompileError{Index: index, Name: rule.Name, Pattern: rule.Pattern, Field: field, Position: -1, Message: err.Error()}
+	var syntaxErr *syntax.Error
+	if errors.As(err, &syntaxErr) {
+		ce.Message = syntaxErr.Code.String()
+		if syntaxErr.Expr != "" {
+			ce.Message += ": `" + syntaxErr.Expr + "`"
+			ce.Position = strings.Index(expr, syntaxErr.Expr)
+		}
+	}
+	return ce
+}
+
+// compiledRule is an active rule with its regular expression
+type compiledRule struct {
+	rule  Rule
+	re    *regexp.Regexp
+	check func(text string, start, end int) bool
+	value int // Submatch index of the "value" group replaced by pseudonyms, or 0 for the whole match
+	tmpl  *template
+	guard *regexp.Regexp
+	scope *regexp.Regexp
+	group int // Submatch index of the scope region in scope
+	state *runState
+}
+
+// Compiled is a rule set ready to be applied many times. Pseudonyms and
+// template counters stay consistent across all calls on the same Compiled.
+type Compiled struct {
+	rules []compiledRule
+}
+
+// runState is shared by the rules of a Compiled. Pseudonyms number distinct
+// values per prefix, so the same email is always user_1; counters back ${n|inc}.
+type runState struct {
+	mu       sync.Mutex
+	tokens   map[string]map[string]string
+	counters map[string]int
+}
+
+func (s *runState) token(prefix, value string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := s.tokens[prefix]
+	if seen == nil {
+		seen = make(map[string]string)
+		s.tokens[prefix] = seen
+	}
+	token, ok := seen[value]
+	if !ok {
+		token = fmt.Sprintf("%s_%d", prefix, len(seen)+1)
+		seen[value] = token
+	}
+	return token
+}
+
+// next increments a counter and returns its new value
+func (s *runState) next(counter string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[counter]++
+	return s.counters[counter]
+}
+
+// Compile compiles the active rules. If any fail, the error is a CompileErrors
+// listing all of them rather than only the first.
+func Compile(rules []Rule) (*Compiled, error) {
+	c := &Compiled{}
+	state := &runState{tokens: make(map[string]map[string]string), counters: make(map[string]int)}
+	var errs CompileErrors
+	for i, rule := range rules {
+		if !rule.Active {
+			continue
+		}
+		re, err := compileCached(rule.expr())
+		if err != nil {
+			errs = append(errs, newCompileError(i, rule, "", rule.Pattern, err))
+			continue
+		}
+		cr := compiledRule{rule: rule, re: re, state: state}
+		if ce := cr.compileScope(i); ce != nil {
+			errs = append(errs, ce)
+			continue
+		}
+		if rule.Check != "" {
+			if cr.check = checks[rule.Check]; cr.check == nil {
+				errs = append(errs, &CompileError{Index: i, Name: rule.Name, Pattern: rule.Pattern, Field: FieldCheck, Position: -1,
+					Message: fmt.Sprintf("unknown check '%s'", rule.Check)})
+				continue
+			}
+		}
+		if vi := re.SubexpIndex("value"); vi > 0 {
+			cr.value = vi
+		}
+		if !rule.Literal && rule.Pseudonym == "" {
+			if cr.tmpl, err = parseTemplate(rule.Replacement, re); err != nil {
+				ce := &CompileError{Index: i, Name: rule.Name, Pattern: rule.Pattern,
+					Field: FieldReplacement, Position: -1, Message: err.Error()}
+				var te *templateError
+				if errors.As(err, &te) {
+					ce.Position, ce.Message = te.pos, te.msg
+				}
+				errs = append(errs, ce)
+				continue
+			}
+		}
+		c.rules = append(c.rules, cr)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return c, nil
+}
+
+// compileScope compiles the guard and scope of a rule
+func (cr *compiledRule) compileScope(index int) *CompileError {
+	rule := cr.rule
+	if rule.Guard != "" {
+		guard, err := compileCached(rule.Guard)
+		if err != nil {
+			return newCompileError(index, rule, FieldGuard, rule.Guard, err)
+		}
+		cr.guard = guard
+	}
+
+	switch rule.Scope {
+	case ScopeText, ScopeLine:
+		// A leftover ScopePattern from an earlier group scope is ignored
+	case ScopeGroup:
+		if rule.ScopePattern == "" {
+			return &CompileError{Index: index, Name: rule.Name, Pattern: rule.Pattern, Field: FieldScopePattern, Position: -1,
+				Message: "missing scope pattern"}
+		}
+		scope, err := compileCached(rule.ScopePattern)
+		if err != nil {
+			return newCompileError(index, rule, FieldScopePattern, rule.ScopePattern, err)
+		}
+		cr.scope = scope
+		if i := scope.SubexpIndex("scope"); i > 0 {
+			cr.group = i
+		} else if scope.NumSubexp() > 0 {
+			cr.group = 1
+		}
+	default:
+		return &CompileError{Index: index, Name: rule.Name, Pattern: rule.Pattern, Field: FieldScope, Position: -1,
+			Message: fmt.Sprintf("unknown scope '%s'", rule.Scope)}
+	}
+	return nil
+}
+
+// Compile compiles the active rules of the set
+func (rs RuleSet) Compile() (*Compiled, error) {
+	return Compile(rs.Rules)
+}
+
+// Validate returns the compile errors of the active rules, or an empty list
+func Validate(rules []Rule) []*CompileError {
+	if _, err := Compile(rules); err != nil {
+		var errs CompileErrors
+		if errors.As(err, &errs) {
+			return errs
+		}
+	}
+	return []*CompileError{}
+}
+
+// Replace applies the rules in order
+func (c *Compiled) Replace(text string) string {
+	for _, cr := range c.rules {
+		text = cr.replace(text)
+	}
+	return text
+}
+
+// replace applies one rule within its scope
+func (cr compiledRule) replace(text string) string {
+	return cr.apply(text, nil)
+}
+
+// apply runs the rule on each unit of its scope that passes the guard. visit
+// receives the spans of every replacement, as for replaceMatches.
+func (cr compiledRule) apply(text string, visit func(in, out [2]int)) string {
+	if cr.rule.Scope == ScopeText {
+		if !cr.guarded(text) {
+			return text
+		}
+		return cr.replaceUnit(text, visit)
+	}
+
+	var out []byte
+	last := 0
+	for _, region := range cr.regions(text) {
+		unit := text[region[0]:region[1]]
+		if !cr.guarded(unit) {
+			continue
+		}
+		out = append(out, text[last:region[0]]...)
+		unitVisit := visit
+		if visit != nil {
+			in, at := region[0], len(out)
+			unitVisit = func(i, o [2]int) {
+				visit([2]int{i[0] + in, i[1] + in}, [2]int{o[0] + at, o[1] + at})
+			}
+		}
+		out = append(out, cr.replaceUnit(unit, unitVisit)...)
+		last = region[1]
+	}
+	if last == 0 && out == nil {
+		return text
+	}
+	return string(append(out, text[last:]...))
+}
+
+// regions returns the byte ranges of the lines, without their line endings,
+// or of the scope pattern's regions
+func (cr compiledRule) regions(text string) [][2]int {
+	var regions [][2]int
+	if cr.scope == nil {
+		for start := 0; start <= len(text); {
+			end := strings.IndexByte(text[start:], '\n')
+			next := start + end + 1
+			if end < 0 {
+				end = len(text) - start
+				next = len(text) + 1
+			}
+			end += start
+			if end > start && text[end-1] == '\r' {
+				end--
+			}
+			regions = append(regions, [2]int{start, end})
+			start = next
+		}
+		return regions
+	}
+
+	for _, loc := range cr.scope.FindAllStringSubmatchIndex(text, -1) {
+		if loc[2*cr.group] >= 0 {
+			regions = append(regions, [2]int{loc[2*cr.group], loc[2*cr.group+1]})
+		}
+	}
+	return regions
+}
+
+// guarded reports whether the guard lets the rule apply to a unit of text
+func (cr compiledRule) guarded(unit string) bool {
+	return cr.guard == nil || cr.guard.MatchString(unit) != cr.rule.GuardNegate
+}
+
+// replaceUnit applies the rule to one unit of its scope, honoring Literal, Limit, Check, Pseudonym and templates
+func (cr compiledRule) replaceUnit(text string, visit func(in, out [2]int)) string {
+	if visit == nil && cr.rule.Limit <= 0 && cr.check == nil && cr.rule.Pseudonym == "" && cr.tmpl == nil {
+		if cr.rule.Literal {
+			return cr.re.ReplaceAllLiteralString(text, cr.rule.Replacement)
+		}
+		return cr.re.ReplaceAllString(text, cr.rule.Replacement)
+	}
+	return cr.replaceMatches(text, visit)
+}
+
+// replaceMatches replaces the matches of the rule one by one and reports the
+// byte span of each match in the input and of its replacement in the output
+func (cr compiledRule) replaceMatches(text string, visit func(in, out [2]int)) string {
+	n := cr.rule.Limit
+	if n <= 0 || cr.check != nil {
+		n = -1 // Matches failing the check do not count towards the limit
+	}
+	matches := cr.re.FindAllStringSubmatchIndex(text, n)
+	if matches == nil {
+		return text
+	}
+	var out []byte
+	last, replaced := 0, 0
+	for _, loc := range matches {
+		if cr.rule.Limit > 0 && replaced == cr.rule.Limit {
+			break
+		}
+		if cr.check != nil && !cr.check(text, loc[0], loc[1]) {
+			continue
+		}
+		out = append(out, text[last:loc[0]]...)
+		start := len(out)
+		switch {
+		case cr.rule.Pseudonym != "":
+			// Only the value group is replaced; the rest of the match is kept
+			vs, ve := loc[2*cr.value], loc[2*cr.value+1]
+			if vs < 0 {
+				vs, ve = loc[0], loc[1]
+			}
+			out = append(out, text[loc[0]:vs]...)
+			out = append(out, cr.state.token(cr.rule.Pseudonym, text[vs:ve])...)
+			out = append(out, text[ve:loc[1]]...)
+		case cr.rule.Literal:
+			out = append(out, cr.rule.Replacement...)
+		case cr.tmpl != nil:
+			out = cr.tmpl.expand(out, cr.re, text, loc, cr.state)
+		default:
+			out = cr.re.ExpandString(out, cr.rule.Replacement, text, loc)
+		}
+		if visit != nil {
+			visit([2]int{loc[0], loc[1]}, [2]int{start, len(out)})
+		}
+		last = loc[1]
+		replaced++
+	}
+	return string(append(out, text[last:]...))
+}
+
+// Len returns the number of active rules
+func (c *Compiled) Len() int {
+	return len(c.rules)
+}
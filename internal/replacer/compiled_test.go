@@ -0,0 +1,185 @@
+package replacer
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const benchLine = `2024-03-01T12:00:00.123Z INFO [worker-7] request id=8f14e45f-ceea-467e-a8c1-12ab34cd56ef ` +
+	`from 192.168.10.24 took 153ms user=alice@example.com pid=4242 addr=0x7ffee4b2c9a8`
+
+// BenchmarkReplace compares a rule set compiled once, or served from the
+// pattern cache, with compiling every pattern on every call as Replace used to
+func BenchmarkReplace(b *testing.B) {
+	rules := VolatileRules()
+
+	b.Run("compiled", func(b *testing.B) {
+		c, err := Compile(rules)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.Replace(benchLine)
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		r := NewReplacer(b.TempDir())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := r.Replace(benchLine, rules); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			text := benchLine
+			for _, rule := range rules {
+				re, err := regexp.Compile(rule.expr())
+				if err != nil {
+					b.Fatal(err)
+				}
+				text = re.ReplaceAllString(text, rule.Replacement)
+			}
+		}
+	})
+}
+
+func TestReplaceMatchesUncompiled(t *testing.T) {
+	rules := VolatileRules()
+	r := NewReplacer(t.TempDir())
+	got, err := r.Replace(benchLine, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := benchLine
+	for _, rule := range rules {
+		want = regexp.MustCompile(rule.expr()).ReplaceAllString(want, rule.Replacement)
+	}
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestCompileErrorsReportsEveryRule(t *testing.T) {
+	rules := []Rule{
+		{Name: "ok", Pattern: `\d+`, Active: true},
+		{Name: "unclosed group", Pattern: `id=(\d+`, Active: true},
+		{Name: "inactive and broken", Pattern: `(`, Active: false},
+		{Name: "bad repeat", Pattern: `ab**`, Active: true},
+		{Name: "bad check", Pattern: `\d+`, Check: "nope", Active: true},
+		{Name: "bad template", Pattern: `(\w+)`, Replacement: `x${1|shout}`, Active: true},
+	}
+
+	_, err := Compile(rules)
+	var errs CompileErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("want CompileErrors, got %v", err)
+	}
+
+	want := []struct {
+		index    int
+		field    string
+		position int
+		message  string
+	}{
+		{1, "", 0, "missing closing )"},
+		{3, "", 2, "invalid nested repetition operator"},
+		{4, FieldCheck, -1, "unknown check 'nope'"},
+		{5, FieldReplacement, 1, "unknown function 'shout'"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d: %v", len(errs), len(want), errs)
+	}
+	for i, w := range want {
+		e := errs[i]
+		if e.Index != w.index || e.Name != rules[w.index].Name || e.Field != w.field || e.Position != w.position {
+			t.Errorf("error %d: got index %d name %q field %q position %d, want index %d name %q field %q position %d",
+				i, e.Index, e.Name, e.Field, e.Position, w.index, rules[w.index].Name, w.field, w.position)
+		}
+		if !strings.Contains(e.Message, w.message) {
+			t.Errorf("error %d: message %q does not mention %q", i, e.Message, w.message)
+		}
+	}
+
+	if got := Validate(rules); len(got) != len(want) {
+		t.Errorf("Validate returned %d errors, want %d", len(got), len(want))
+	}
+	if got := Validate(rules[:1]); len(got) != 0 {
+		t.Errorf("Validate of a valid set returned %v", got)
+	}
+}
+
+func TestScopesAndGuards(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		input string
+		want  string
+	}{
+		{"text scope limit counts across lines", Rule{Pattern: `\d`, Replacement: "#", Limit: 1}, "1 2\n3 4", "# 2\n3 4"},
+		{"line scope limit counts per line", Rule{Pattern: `\d`, Replacement: "#", Limit: 1, Scope: ScopeLine}, "1 2\r\n3 4\n\n5", "# 2\r\n# 4\n\n#"},
+		{"text guard is all or nothing", Rule{Pattern: `\d+`, Replacement: "N", Guard: "ERROR"}, "ERROR 1\nINFO 2", "ERROR N\nINFO N"},
+		{"text guard not matching", Rule{Pattern: `\d+`, Replacement: "N", Guard: "FATAL"}, "ERROR 1\nINFO 2", "ERROR 1\nINFO 2"},
+		{"line guard", Rule{Pattern: `\d+`, Replacement: "N", Guard: "ERROR", Scope: ScopeLine}, "ERROR 1\nINFO 2\nERROR 3", "ERROR N\nINFO 2\nERROR N"},
+		{"negated line guard", Rule{Pattern: `\d+`, Replacement: "N", Guard: "ERROR", GuardNegate: true, Scope: ScopeLine}, "ERROR 1\nINFO 2", "ERROR 1\nINFO N"},
+		{"line anchors", Rule{Pattern: `^\w+$`, Replacement: "X", Scope: ScopeLine}, "one\ntwo\r\nthree four", "X\nX\r\nthree four"},
+		{"group scope uses the scope group", Rule{Pattern: `\d`, Replacement: "#", Scope: ScopeGroup, ScopePattern: `(\w+)=(?P<scope>[^ ]*)`},
+			"a1=b2 c3=d4", "a1=b# c3=d#"},
+		{"group scope uses the first group", Rule{Pattern: `\d`, Replacement: "#", Scope: ScopeGroup, ScopePattern: `"([^"]*)"`},
+			`1 "a2 b3" 4 "5"`, `1 "a# b#" 4 "#"`},
+		{"group scope uses the whole match", Rule{Pattern: `\d`, Replacement: "#", Scope: ScopeGroup, ScopePattern: `\[[^]]*\]`},
+			"1 [2 3] 4", "1 [# #] 4"},
+		{"group scope with guard and limit", Rule{Pattern: `\d`, Replacement: "#", Scope: ScopeGroup, ScopePattern: `\[[^]]*\]`, Guard: "id", Limit: 1},
+			"[id 1 2] [no 3] [id 4]", "[id # 2] [no 3] [id #]"},
+		{"group scope without regions", Rule{Pattern: `\d`, Replacement: "#", Scope: ScopeGroup, ScopePattern: `\[[^]]*\]`}, "1 2", "1 2"},
+		{"leftover scope pattern ignored", Rule{Pattern: `\d`, Replacement: "#", Scope: ScopeLine, ScopePattern: `\[`}, "1 [2]", "# [#]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Active = true
+			c, err := Compile([]Rule{tt.rule})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Replace(tt.input); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeErrors(t *testing.T) {
+	rules := []Rule{
+		{Name: "bad guard", Pattern: `\d`, Guard: "(", Active: true},
+		{Name: "no scope pattern", Pattern: `\d`, Scope: ScopeGroup, Active: true},
+		{Name: "bad scope pattern", Pattern: `\d`, Scope: ScopeGroup, ScopePattern: "a[", Active: true},
+		{Name: "unknown scope", Pattern: `\d`, Scope: "word", Active: true},
+	}
+	errs := Validate(rules)
+	want := []struct {
+		field   string
+		message string
+	}{
+		{FieldGuard, "missing closing )"},
+		{FieldScopePattern, "missing scope pattern"},
+		{FieldScopePattern, "missing closing ]"},
+		{FieldScope, "unknown scope 'word'"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d: %v", len(errs), len(want), errs)
+	}
+	for i, w := range want {
+		if errs[i].Index != i || errs[i].Field != w.field || !strings.Contains(errs[i].Message, w.message) {
+			t.Errorf("error %d: got index %d field %q message %q, want field %q message %q",
+				i, errs[i].Index, errs[i].Field, errs[i].Message, w.field, w.message)
+		}
+	}
+}
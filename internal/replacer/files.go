@@ -0,0 +1,459 @@
+package replacer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxPreview    = 20 // Changed lines kept per file in the preview
+	maxPreviewLen = 2048
+	sniffSize     = 8 * 1024
+	progressEvery = 8 * 1024 * 1024
+)
+
+// maxWholeFile is the largest file WholeFile mode loads in memory; larger files are skipped
+var maxWholeFile int64 = 256 * 1024 * 1024
+
+// FileOptions defines how a rule set is applied to files on disk
+type FileOptions struct {
+	Paths     []string `json:"paths"` // Files and directories to process
+	Rules     []Rule   `json:"rules"`
+	Recursive bool     `json:"recursive"` // Descend into subdirectories
+	Include   string   `json:"include"`   // Glob matched against file names, e.g. "*.log"; empty for all
+	OutputDir string   `json:"outputDir"` // Write results here, mirroring the input layout, instead of in place
+	DryRun    bool     `json:"dryRun"`    // Only report and preview the changes
+	Backup    bool     `json:"backup"`    // Keep the original as <file>.bak (or .bak.N if taken) when rewriting in place
+	WholeFile bool     `json:"wholeFile"` // Apply rules to whole files so patterns can span lines; loads each file in memory, up to 256MB
+}
+
+// LineChange is one changed line in a preview
+type LineChange struct {
+	Line   int    `json:"line"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// FileResult describes what happened to one file
+type FileResult struct {
+	Path         string       `json:"path"`
+	Output       string       `json:"output,omitempty"` // Written file; empty for dry runs and unchanged in-place files
+	Backup       string       `json:"backup,omitempty"`
+	Changed      bool         `json:"changed"`
+	ChangedLines int64        `json:"changedLines"`
+	Preview      []LineChange `json:"preview,omitempty"`
+	Skipped      string       `json:"skipped,omitempty"` // Why the file was not processed
+	Error        string       `json:"error,omitempty"`
+}
+
+// FileSummary is the outcome of applying a rule set to files
+type FileSummary struct {
+	Files        []FileResult `json:"files"`
+	Changed      int          `json:"changed"`
+	ChangedLines int64        `json:"changedLines"`
+	Failed       int          `json:"failed"`
+}
+
+// fileJob is a file to process and where its output goes
+type fileJob struct {
+	path   string
+	output string
+	size   int64
+}
+
+// ApplyToFiles runs the rules over files and directories. By default files are
+// streamed line by line, so their size does not matter; WholeFile mode lets
+// patterns match across lines. A failing file is reported in its result and
+// does not stop the others.
+func (r *Replacer) ApplyToFiles(ctx context.Context, opts FileOptions, progress chan<- float64) (FileSummary, error) {
+	summary := FileSummary{Files: []FileResult{}}
+
+	c, err := Compile(opts.Rules)
+	if err != nil {
+		return summary, err
+	}
+	if opts.Include != "" {
+		if _, err := filepath.Match(opts.Include, ""); err != nil {
+			return summary, fmt.Errorf("invalid include pattern '%s': %v", opts.Include, err)
+		}
+	}
+
+	jobs, err := collectFiles(opts)
+	if err != nil {
+		return summary, err
+	}
+
+	var totalSize, done int64
+	for _, job := range jobs {
+		totalSize += job.size
+	}
+	var lastReport int64
+	report := func(n int64) {
+		done += n
+		if progress != nil && totalSize > 0 && done-lastReport >= progressEvery {
+			lastReport = done
+			progress <- float64(done) / float64(totalSize) * 100
+		}
+	}
+
+	for _, job := range jobs {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+
+		res := r.applyToFile(ctx, c, opts, job, report)
+		if res.Error != "" {
+			if err := ctx.Err(); err != nil {
+				return summary, err
+			}
+			summary.Failed++
+		}
+		if res.Changed {
+			summary.Changed++
+			summary.ChangedLines += res.ChangedLines
+		}
+		summary.Files = append(summary.Files, res)
+	}
+
+	if progress != nil {
+		progress <- 100
+	}
+	return summary, nil
+}
+
+// collectFiles expands directories and computes output paths
+func collectFiles(opts FileOptions) ([]fileJob, error) {
+	var jobs []fileJob
+	seen := make(map[string]bool)
+	add := func(path, rel string, size int64) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		job := fileJob{path: path, size: size}
+		if opts.OutputDir != "" {
+			job.output = filepath.Join(opts.OutputDir, rel)
+		}
+		jobs = append(jobs, job)
+	}
+
+	for _, root := range opts.Paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			add(root, filepath.Base(root), info.Size())
+			continue
+		}
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != root && !opts.Recursive {
+					return filepath.SkipDir
+				}
+				if opts.OutputDir != "" && filepath.Clean(path) == filepath.Clean(opts.OutputDir) {
+					return filepath.SkipDir // Do not process our own output
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || isOwnFile(d.Name()) {
+				return nil
+			}
+			if opts.Include != "" {
+				if ok, _ := filepath.Match(opts.Include, d.Name()); !ok {
+					return nil
+				}
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			add(path, rel, info.Size())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Inputs with the same name in different places would overwrite each other's output
+	if opts.OutputDir != "" {
+		outputs := make(map[string]string, len(jobs))
+		for _, job := range jobs {
+			key := filepath.Clean(job.output)
+			if first, ok := outputs[key]; ok {
+				return nil, fmt.Errorf("%s and %s would both be written to %s; process them separately", first, job.path, job.output)
+			}
+			outputs[key] = job.path
+		}
+	}
+	return jobs, nil
+}
+
+// applyToFile processes one file and never returns an error; failures are recorded in the result
+func (r *Replacer) applyToFile(ctx context.Context, c *Compiled, opts FileOptions, job fileJob, report func(int64)) FileResult {
+	res := FileResult{Path: job.path}
+	fail := func(err error) FileResult {
+		res.Error = err.Error()
+		res.Output = ""
+		res.Backup = ""
+		return res
+	}
+
+	if job.output != "" && samePath(job.output, job.path) {
+		return fail(fmt.Errorf("output would overwrite the input"))
+	}
+
+	in, err := os.Open(job.path)
+	if err != nil {
+		return fail(err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return fail(err)
+	}
+
+	head := make([]byte, sniffSize)
+	n, err := in.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return fail(err)
+	}
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		res.Skipped = "binary file"
+		report(job.size)
+		return res
+	}
+	if opts.WholeFile && info.Size() > maxWholeFile {
+		res.Skipped = fmt.Sprintf("larger than %d MB, too large for whole-file mode", maxWholeFile>>20)
+		report(job.size)
+		return res
+	}
+
+	// Output goes to a temporary file next to its destination and is renamed into place when complete
+	var out *os.File
+	dest := job.output
+	if dest == "" {
+		dest = job.path
+	}
+	if !opts.DryRun {
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return fail(err)
+		}
+		out, err = os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*.tmp")
+		if err != nil {
+			return fail(err)
+		}
+		defer func() {
+			if out != nil {
+				out.Close()
+				os.Remove(out.Name())
+			}
+		}()
+	}
+
+	var w *bufio.Writer
+	if out != nil {
+		w = bufio.NewWriterSize(out, 1024*1024)
+	}
+
+	if opts.WholeFile {
+		err = applyWhole(c, in, w, &res)
+	} else {
+		err = applyLines(ctx, c, in, w, &res, report)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if opts.WholeFile {
+		report(job.size)
+	}
+
+	if opts.DryRun || (job.output == "" && !res.Changed) {
+		return res // Nothing to write, or an unchanged file is left untouched
+	}
+
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		return fail(err)
+	}
+
+	if job.output == "" && opts.Backup {
+		res.Backup = backupPath(job.path)
+		if err := os.Rename(job.path, res.Backup); err != nil {
+			return fail(err)
+		}
+	}
+	if err := os.Rename(out.Name(), dest); err != nil {
+		if res.Backup != "" {
+			os.Rename(res.Backup, job.path) // Put the original back
+		}
+		return fail(err)
+	}
+	out = nil
+	res.Output = dest
+	return res
+}
+
+// applyLines streams a file line by line, keeping line endings as they were
+func applyLines(ctx context.Context, c *Compiled, in io.Reader, w *bufio.Writer, res *FileResult, report func(int64)) error {
+	reader := bufio.NewReaderSize(in, 1024*1024)
+	for line := 1; ; line++ {
+		if line&4095 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		raw, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if raw == "" {
+			return nil
+		}
+		report(int64(len(raw)))
+
+		text := strings.TrimSuffix(raw, "\n")
+		text = strings.TrimSuffix(text, "\r")
+		ending := raw[len(text):]
+
+		replaced := c.Replace(text)
+		if replaced != text {
+			res.Changed = true
+			res.ChangedLines++
+			if len(res.Preview) < maxPreview {
+				res.Preview = append(res.Preview, LineChange{Line: line, Before: clip(text), After: clip(replaced)})
+			}
+		}
+		if w != nil {
+			if _, err := w.WriteString(replaced + ending); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// applyWhole applies the rules to the whole file at once. The preview shows
+// the changed region between the common head and tail of both versions.
+func applyWhole(c *Compiled, in io.Reader, w *bufio.Writer, res *FileResult) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	text := string(data)
+	replaced := c.Replace(text)
+
+	if replaced != text {
+		res.Changed = true
+		prefix := 0
+		for prefix < len(text) && prefix < len(replaced) && text[prefix] == replaced[prefix] {
+			prefix++
+		}
+		suffix := 0
+		for suffix < len(text)-prefix && suffix < len(replaced)-prefix &&
+			text[len(text)-1-suffix] == replaced[len(replaced)-1-suffix] {
+			suffix++
+		}
+		// Widen the region to whole lines
+		start := strings.LastIndexByte(text[:prefix], '\n') + 1
+		before := text[start : len(text)-suffix]
+		after := replaced[start : len(replaced)-suffix]
+		if i := strings.IndexByte(text[len(text)-suffix:], '\n'); i >= 0 {
+			before += text[len(text)-suffix:][:i]
+			after += text[len(text)-suffix:][:i]
+		} else {
+			before += text[len(text)-suffix:]
+			after += text[len(text)-suffix:]
+		}
+		res.ChangedLines = int64(strings.Count(before, "\n") + 1)
+		res.Preview = []LineChange{{
+			Line:   strings.Count(text[:start], "\n") + 1,
+			Before: clip(before),
+			After:  clip(after),
+		}}
+	}
+
+	if w != nil {
+		_, err = w.WriteString(replaced)
+	}
+	return err
+}
+
+// clip shortens preview text
+func clip(s string) string {
+	if len(s) <= maxPreviewLen {
+		return s
+	}
+	cut := maxPreviewLen
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
+
+// backupPath returns the first free backup name for a file: <file>.bak, then
+// <file>.bak.1, <file>.bak.2... so a later run never replaces the backup of the original
+func backupPath(path string) string {
+	backup := path + ".bak"
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			return backup
+		}
+		backup = fmt.Sprintf("%s.bak.%d", path, n)
+	}
+}
+
+// isOwnFile reports whether a file name is a backup or temporary file written
+// by ApplyToFiles, which directory runs leave alone
+func isOwnFile(name string) bool {
+	if strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".tmp") {
+		return true
+	}
+	if strings.HasSuffix(name, ".bak") {
+		return true
+	}
+	i := strings.LastIndex(name, ".bak.")
+	if i < 0 {
+		return false
+	}
+	_, err := strconv.Atoi(name[i+len(".bak."):])
+	return err == nil
+}
+
+// samePath reports whether two paths name the same file
+func samePath(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
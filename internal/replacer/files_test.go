@@ -0,0 +1,226 @@
+package replacer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var numberRules = []Rule{{Name: "number", Pattern: `\d+`, Replacement: "<N>", Active: true}}
+
+// writeFiles creates files under dir from a map of slash-separated relative paths to contents
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func applyFiles(t *testing.T, opts FileOptions) FileSummary {
+	t.Helper()
+	if opts.Rules == nil {
+		opts.Rules = numberRules
+	}
+	summary, err := NewReplacer(t.TempDir()).ApplyToFiles(context.Background(), opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+func TestApplyToOutputDir(t *testing.T) {
+	in, out := t.TempDir(), filepath.Join(t.TempDir(), "out")
+	writeFiles(t, in, map[string]string{
+		"a.log":         "took 15ms\r\nok\r\n",
+		"sub/b.log":     "pid 42\nno digits",
+		"sub/notes.txt": "id 7\n",
+		"a.log.bak":     "backup 1\n",
+		".a.log.x.tmp":  "temp 2\n",
+	})
+
+	summary := applyFiles(t, FileOptions{Paths: []string{in}, Recursive: true, Include: "*.log", OutputDir: out})
+	if len(summary.Files) != 2 || summary.Changed != 2 || summary.ChangedLines != 2 || summary.Failed != 0 {
+		t.Fatalf("got %+v", summary)
+	}
+
+	want := map[string]string{"a.log": "took <N>ms\r\nok\r\n", "sub/b.log": "pid <N>\nno digits"}
+	for name, content := range want {
+		if got := readFile(t, filepath.Join(out, filepath.FromSlash(name))); got != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+	if got := readFile(t, filepath.Join(in, "a.log")); got != "took 15ms\r\nok\r\n" {
+		t.Errorf("input changed: %q", got)
+	}
+	entries, _ := os.ReadDir(out)
+	if len(entries) != 2 {
+		t.Errorf("output dir has %d entries, want a.log and sub", len(entries))
+	}
+}
+
+func TestApplyInPlaceWithBackup(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.log": "id 1\n", "same.log": "no digits\n"})
+	path := filepath.Join(dir, "app.log")
+
+	for i, want := range []string{path + ".bak", path + ".bak.1"} {
+		writeFiles(t, dir, map[string]string{"app.log": "id 1\n"})
+		summary := applyFiles(t, FileOptions{Paths: []string{path, filepath.Join(dir, "same.log")}, Backup: true})
+		res := summary.Files[0]
+		if res.Backup != want || res.Output != path {
+			t.Errorf("run %d: got backup %q output %q, want backup %q", i, res.Backup, res.Output, want)
+		}
+		if got := readFile(t, want); got != "id 1\n" {
+			t.Errorf("run %d: backup holds %q", i, got)
+		}
+		if got := readFile(t, path); got != "id <N>\n" {
+			t.Errorf("run %d: file holds %q", i, got)
+		}
+		if unchanged := summary.Files[1]; unchanged.Changed || unchanged.Output != "" || unchanged.Backup != "" {
+			t.Errorf("run %d: unchanged file was rewritten: %+v", i, unchanged)
+		}
+	}
+	if got := readFile(t, path+".bak"); got != "id 1\n" {
+		t.Errorf("second run replaced the first backup with %q", got)
+	}
+}
+
+func TestApplyDryRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.log": "a 1\nb\nc 3\n"})
+	path := filepath.Join(dir, "app.log")
+
+	summary := applyFiles(t, FileOptions{Paths: []string{path}, DryRun: true, Backup: true})
+	res := summary.Files[0]
+	if !res.Changed || res.ChangedLines != 2 || res.Output != "" || res.Backup != "" {
+		t.Errorf("got %+v", res)
+	}
+	want := []LineChange{{Line: 1, Before: "a 1", After: "a <N>"}, {Line: 3, Before: "c 3", After: "c <N>"}}
+	if len(res.Preview) != len(want) || res.Preview[0] != want[0] || res.Preview[1] != want[1] {
+		t.Errorf("got preview %+v, want %+v", res.Preview, want)
+	}
+	if got := readFile(t, path); got != "a 1\nb\nc 3\n" {
+		t.Errorf("dry run changed the file: %q", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("dry run left %d files", len(entries))
+	}
+}
+
+func TestApplyWholeFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.log": "start\nBEGIN\nsecret 1\nEND\nafter 2\n"})
+	path := filepath.Join(dir, "app.log")
+	rules := []Rule{{Name: "block", Pattern: `(?s)BEGIN.*?END`, Replacement: "[removed]", Active: true}}
+
+	summary := applyFiles(t, FileOptions{Paths: []string{path}, Rules: rules, WholeFile: true})
+	if got := readFile(t, path); got != "start\n[removed]\nafter 2\n" {
+		t.Errorf("got %q", got)
+	}
+	res := summary.Files[0]
+	if len(res.Preview) != 1 || res.Preview[0].Line != 2 || res.Preview[0].Before != "BEGIN\nsecret 1\nEND" || res.ChangedLines != 3 {
+		t.Errorf("got %+v", res)
+	}
+}
+
+func TestApplySkips(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"bin.dat": "id 1\x00\x01", "big.log": "id 1\n" + strings.Repeat("x", 100)})
+
+	defer func(limit int64) { maxWholeFile = limit }(maxWholeFile)
+	maxWholeFile = 64
+
+	summary := applyFiles(t, FileOptions{Paths: []string{filepath.Join(dir, "bin.dat"), filepath.Join(dir, "big.log")}, WholeFile: true})
+	if got := summary.Files[0]; got.Skipped != "binary file" || got.Changed {
+		t.Errorf("binary file: got %+v", got)
+	}
+	if got := summary.Files[1]; !strings.Contains(got.Skipped, "too large") || got.Changed {
+		t.Errorf("oversized file: got %+v", got)
+	}
+	if summary.Changed != 0 || summary.Failed != 0 {
+		t.Errorf("got %+v", summary)
+	}
+	if got := readFile(t, filepath.Join(dir, "big.log")); !strings.HasPrefix(got, "id 1\n") {
+		t.Errorf("skipped file was changed: %q", got)
+	}
+
+	// Line mode streams, so the size does not matter
+	summary = applyFiles(t, FileOptions{Paths: []string{filepath.Join(dir, "big.log")}})
+	if got := summary.Files[0]; got.Skipped != "" || !got.Changed {
+		t.Errorf("line mode: got %+v", got)
+	}
+}
+
+func TestApplyRejectsCollidingOutputs(t *testing.T) {
+	in, out := t.TempDir(), filepath.Join(t.TempDir(), "out")
+	writeFiles(t, in, map[string]string{"a/app.log": "id 1\n", "b/app.log": "id 2\n"})
+
+	tests := []struct {
+		name  string
+		paths []string
+	}{
+		{"files", []string{filepath.Join(in, "a", "app.log"), filepath.Join(in, "b", "app.log")}},
+		{"directories", []string{filepath.Join(in, "a"), filepath.Join(in, "b")}},
+		{"file and directory", []string{filepath.Join(in, "a", "app.log"), filepath.Join(in, "b")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := FileOptions{Paths: tt.paths, Rules: numberRules, OutputDir: out}
+			_, err := NewReplacer(t.TempDir()).ApplyToFiles(context.Background(), opts, nil)
+			if err == nil || !strings.Contains(err.Error(), "would both be written to") {
+				t.Fatalf("got %v, want a collision error", err)
+			}
+			if _, err := os.Stat(out); !os.IsNotExist(err) {
+				t.Errorf("output written despite the collision: %v", err)
+			}
+		})
+	}
+
+	// The same input given twice is processed once
+	summary := applyFiles(t, FileOptions{Paths: []string{filepath.Join(in, "a", "app.log"), filepath.Join(in, "a", "app.log")}, OutputDir: out})
+	if len(summary.Files) != 1 {
+		t.Errorf("got %d results for one file", len(summary.Files))
+	}
+}
+
+func TestApplyOutputOverInput(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"app.log": "id 1\n"})
+
+	summary := applyFiles(t, FileOptions{Paths: []string{filepath.Join(dir, "app.log")}, OutputDir: dir})
+	if res := summary.Files[0]; !strings.Contains(res.Error, "overwrite the input") || summary.Failed != 1 {
+		t.Errorf("got %+v", summary)
+	}
+	if got := readFile(t, filepath.Join(dir, "app.log")); got != "id 1\n" {
+		t.Errorf("input changed: %q", got)
+	}
+}
+
+func TestIsOwnFile(t *testing.T) {
+	tests := map[string]bool{
+		"app.log": false, "app.log.bak": true, "app.log.bak.3": true, "app.log.bak.old": false,
+		".app.log.123.tmp": true, "app.tmp": false, "backup.log": false,
+	}
+	for name, want := range tests {
+		if got := isOwnFile(name); got != want {
+			t.Errorf("isOwnFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -6,22 +6,46 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+
+	"logana/internal/fileutil"
 )
 
 // Rule defines a single replacement rule
 type Rule struct {
-	Name        string `json:"name"`
-	Pattern     string `json:"pattern"`
-	Replacement string `json:"replacement"`
-	Active      bool   `json:"active"`
-	DotAll      bool   `json:"dotAll"`    // Enable (?s) flag: . matches \n
-	Collapsed   bool   `json:"collapsed"` // UI state: whether the rule is collapsed in sidebar
+	Name         string `json:"name"`
+	Pattern      string `json:"pattern"`
+	Replacement  string `json:"replacement"` // $1 and ${name} references, plus ${ref|func...} templates
+	Active       bool   `json:"active"`
+	DotAll       bool   `json:"dotAll"`                 // Enable (?s) flag: . matches \n
+	IgnoreCase   bool   `json:"ignoreCase,omitempty"`   // Enable (?i) flag: case-insensitive matching
+	Multiline    bool   `json:"multiline,omitempty"`    // Enable (?m) flag: ^ and $ match at line breaks
+	Ungreedy     bool   `json:"ungreedy,omitempty"`     // Enable (?U) flag: swap greedy and lazy quantifiers
+	WholeWord    bool   `json:"wholeWord,omitempty"`    // Only match at word boundaries
+	Literal      bool   `json:"literal,omitempty"`      // Pattern and replacement are plain text, not regex/template
+	Limit        int    `json:"limit,omitempty"`        // Replace only the first N matches; 0 replaces all
+	Check        string `json:"check,omitempty"`        // Validator a match must pass to be replaced: "luhn", "cn-id", "ipv6" or "phone"
+	Pseudonym    string `json:"pseudonym,omitempty"`    // Replace each distinct match (or its "value" group) with <prefix>_<n>
+	Guard        string `json:"guard,omitempty"`        // Only apply the rule where this pattern matches (per unit of the scope)
+	GuardNegate  bool   `json:"guardNegate,omitempty"`  // Apply where Guard does not match instead
+	Scope        string `json:"scope,omitempty"`        // Where the rule applies: "" (whole text), "line" or "group"
+	ScopePattern string `json:"scopePattern,omitempty"` // For the "group" scope, ignored otherwise: regions are its "scope" group, first group or whole match
+	Collapsed    bool   `json:"collapsed"`              // UI state: whether the rule is collapsed in sidebar
 }
 
+// Rule scopes. A rule with the line or group scope runs separately on each
+// line or region, so its Guard and Limit apply per unit.
+const (
+	ScopeText  = ""
+	ScopeLine  = "line"
+	ScopeGroup = "group"
+)
+
 // RuleSet defines a named set of rules
 type RuleSet struct {
-	Name  string `json:"name"`
-	Rules []Rule `json:"rules"`
+	Name    string     `json:"name"`
+	Rules   []Rule     `json:"rules"`
+	Tests   []RuleTest `json:"tests,omitempty"`   // Examples the rules must keep passing
+	Builtin bool       `json:"builtin,omitempty"` // Shipped with the app; copy it to customize
 }
 
 // Replacer handles the replacement logic
@@ -36,58 +60,95 @@ func NewReplacer(appConfigDir string) *Replacer {
 	}
 }
 
-// Replace performs multiple regex replacements on the input text
+// expr returns the pattern with the rule's flags applied
+func (rule Rule) expr() string {
+	pattern := rule.Pattern
+	if rule.Literal {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if rule.WholeWord {
+		pattern = `\b(?:` + pattern + `)\b`
+	}
+
+	flags := ""
+	if rule.IgnoreCase {
+		flags += "i"
+	}
+	if rule.Multiline {
+		flags += "m"
+	}
+	if rule.DotAll {
+		flags += "s"
+	}
+	if rule.Ungreedy {
+		flags += "U"
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return pattern
+}
+
+// Compile builds the regular expression for the rule with its flags applied
+func (rule Rule) Compile() (*regexp.Regexp, error) {
+	re, err := compileCached(rule.expr())
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern '%s': %v", rule.Pattern, err)
+	}
+	return re, nil
+}
+
+// Replace performs multiple regex replacements on the input text.
+// Compiled patterns are cached, and all invalid rules are reported together.
 func (r *Replacer) Replace(text string, rules []Rule) (string, error) {
-	result := text
-	for _, rule := range rules {
-		if !rule.Active {
-			continue
-		}
-		pattern := rule.Pattern
-		if rule.DotAll {
-			pattern = "(?s)" + pattern
-		}
-		re, err := regexp.Compile(pattern)
-		if err != nil {
-			return "", fmt.Errorf("invalid regex pattern '%s': %v", rule.Pattern, err)
-		}
-		result = re.ReplaceAllString(result, rule.Replacement)
+	c, err := Compile(rules)
+	if err != nil {
+		return "", err
 	}
-	return result, nil
+	return c.Replace(text), nil
 }
 
-// SaveRuleSets saves all rule sets to a local file
-func (r *Replacer) SaveRuleSets(ruleSets []RuleSet) error {
+// SaveRuleSets saves all rule sets to a local file. The file is replaced
+// atomically and the previous version is kept, see storage.go. The sets are
+// saved even when their tests fail; the failing reports are returned as a warning.
+func (r *Replacer) SaveRuleSets(ruleSets []RuleSet) ([]TestReport, error) {
 	dir := filepath.Dir(r.configPath)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.MkdirAll(dir, 0o755); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	data, err := json.MarshalIndent(ruleSets, "", "  ")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return os.WriteFile(r.configPath, data, 0o644)
+	if err := r.keepPrevious(data); err != nil {
+		return nil, err
+	}
+	if err := fileutil.WriteAtomic(r.configPath, data, 0o644); err != nil {
+		return nil, err
+	}
+	return r.testRuleSets(ruleSets), nil
 }
 
-// LoadRuleSets loads all rule sets from the local file
-func (r *Replacer) LoadRuleSets() ([]RuleSet, error) {
+// LoadRuleSets loads all rule sets from the local file. If the file is
+// corrupt, the newest valid backup is loaded instead and warning says so.
+func (r *Replacer) LoadRuleSets() (ruleSets []RuleSet, warning string, err error) {
 	if _, err := os.Stat(r.configPath); os.IsNotExist(err) {
-		return []RuleSet{}, nil
+		return []RuleSet{}, "", nil
 	}
 
 	data, err := os.ReadFile(r.configPath)
 	if err != nil {
-		return nil, err
+		return r.recoverRuleSets(err)
 	}
 
-	var ruleSets []RuleSet
-	if err := json.Unmarshal(data, &ruleSets); err != nil {
-		return nil, err
+	ruleSets, err = parseRuleSets(data)
+	if err != nil {
+		return r.recoverRuleSets(err)
 	}
 
-	return ruleSets, nil
+	return ruleSets, "", nil
 }
@@ -0,0 +1,75 @@
+package replacer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRuleFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		input string
+		want  string
+	}{
+		{"case sensitive by default", Rule{Pattern: "error", Replacement: "E"}, "Error error", "Error E"},
+		{"ignore case", Rule{Pattern: "error", Replacement: "E", IgnoreCase: true}, "Error ERROR error", "E E E"},
+		{"anchors match the text by default", Rule{Pattern: `^\w+`, Replacement: "X"}, "one\ntwo", "X\ntwo"},
+		{"multiline", Rule{Pattern: `^\w+$`, Replacement: "X", Multiline: true}, "one\ntwo\nthree four", "X\nX\nthree four"},
+		{"dot stops at newlines by default", Rule{Pattern: `a.b`, Replacement: "X"}, "a\nb a-b", "a\nb X"},
+		{"dot all", Rule{Pattern: `a.b`, Replacement: "X", DotAll: true}, "a\nb a-b", "X X"},
+		{"greedy by default", Rule{Pattern: `<.+>`, Replacement: "X"}, "<a><b>", "X"},
+		{"ungreedy", Rule{Pattern: `<.+>`, Replacement: "X", Ungreedy: true}, "<a><b>", "XX"},
+		{"ungreedy makes lazy quantifiers greedy", Rule{Pattern: `<.+?>`, Replacement: "X", Ungreedy: true}, "<a><b>", "X"},
+		{"whole word", Rule{Pattern: "id", Replacement: "ID", WholeWord: true}, "id idle rid id.", "ID idle rid ID."},
+		{"whole word wraps alternations", Rule{Pattern: "in|out", Replacement: "X", WholeWord: true}, "in input out", "X input X"},
+		{"literal pattern", Rule{Pattern: "a.b(1)", Replacement: "X", Literal: true}, "a.b(1) axb(1)", "X axb(1)"},
+		{"literal replacement", Rule{Pattern: `(\d+)`, Replacement: "$1${x}", Literal: true}, "(\\d+) 7", "$1${x} 7"},
+		{"limit", Rule{Pattern: `\d`, Replacement: "#", Limit: 2}, "1 2 3 4", "# # 3 4"},
+		{"limit above the match count", Rule{Pattern: `\d`, Replacement: "#", Limit: 9}, "1 2", "# #"},
+		{"flags combine", Rule{Pattern: "^err.r$", Replacement: "X", IgnoreCase: true, Multiline: true, WholeWord: true}, "ok\nERROR\nerr-r x", "ok\nX\nerr-r x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Active = true
+			c, err := Compile([]Rule{tt.rule})
+			if err != nil {
+				t.Fatalf("compile %q: %v", tt.rule.expr(), err)
+			}
+			if got := c.Replace(tt.input); got != tt.want {
+				t.Errorf("%q on %q: got %q, want %q", tt.rule.expr(), tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleExpr(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{Pattern: `\d+`}, `\d+`},
+		{Rule{Pattern: `a.b`, IgnoreCase: true, Multiline: true, DotAll: true, Ungreedy: true}, `(?imsU)a.b`},
+		{Rule{Pattern: `a.b`, Literal: true, WholeWord: true, IgnoreCase: true}, `(?i)\b(?:a\.b)\b`},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.expr(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestRuleFlagsDefaultOff(t *testing.T) {
+	// Rule files saved before the flags existed load with all of them off
+	var rules []Rule
+	if err := json.Unmarshal([]byte(`[{"name":"n","pattern":"\\d","replacement":"#","active":true,"dotAll":false,"collapsed":false}]`), &rules); err != nil {
+		t.Fatal(err)
+	}
+	r := rules[0]
+	if r.IgnoreCase || r.Multiline || r.Ungreedy || r.WholeWord || r.Literal || r.Limit != 0 {
+		t.Errorf("got %+v", r)
+	}
+	if got, err := NewReplacer(t.TempDir()).Replace("1 2", rules); err != nil || got != "# #" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,149 @@
+package replacer
+
+import "strings"
+
+// maxDiffCells bounds the line diff table; larger outputs get a plain before/after diff
+const maxDiffCells = 1 << 20
+
+// RuleTest is an example input and the output the rule set must produce for it
+type RuleTest struct {
+	Name     string `json:"name,omitempty"`
+	Input    string `json:"input"`
+	Expected string `json:"expected"`
+}
+
+// TestResult is the outcome of one RuleTest
+type TestResult struct {
+	Name     string `json:"name,omitempty"`
+	Input    string `json:"input"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Passed   bool   `json:"passed"`
+	Offset   int    `json:"offset"`         // Byte offset of the first difference, or -1
+	Diff     string `json:"diff,omitempty"` // Line diff: "- " expected, "+ " actual, "  " common
+}
+
+// TestReport is the outcome of running the tests of a rule set
+type TestReport struct {
+	RuleSet string       `json:"ruleSet"`
+	Passed  int          `json:"passed"`
+	Failed  int          `json:"failed"`
+	Error   string       `json:"error,omitempty"` // Set when the rules do not compile, so no test ran
+	Results []TestResult `json:"results"`
+}
+
+// OK reports whether every test passed
+func (tr TestReport) OK() bool {
+	return tr.Error == "" && tr.Failed == 0
+}
+
+// TestRuleSet runs the tests of a rule set. Each test starts from fresh
+// state, so pseudonyms and counters are numbered from 1 in every case.
+func (r *Replacer) TestRuleSet(rs RuleSet) TestReport {
+	report := TestReport{RuleSet: rs.Name, Results: []TestResult{}}
+	if _, err := rs.Compile(); err != nil {
+		report.Error = err.Error()
+		report.Failed = len(rs.Tests)
+		return report
+	}
+
+	for _, test := range rs.Tests {
+		c, _ := rs.Compile()
+		actual := c.Replace(test.Input)
+		res := TestResult{
+			Name:     test.Name,
+			Input:    test.Input,
+			Expected: test.Expected,
+			Actual:   actual,
+			Passed:   actual == test.Expected,
+			Offset:   -1,
+		}
+		if res.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+			res.Offset = firstDifference(test.Expected, actual)
+			res.Diff = diffLines(test.Expected, actual)
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report
+}
+
+// firstDifference returns the byte offset where two strings start to differ
+func firstDifference(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// diffLines returns a line diff from expected to actual
+func diffLines(expected, actual string) string {
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+	var sb strings.Builder
+	line := func(prefix, text string) {
+		sb.WriteString(prefix)
+		sb.WriteString(text)
+		sb.WriteByte('\n')
+	}
+
+	if len(a)*len(b) > maxDiffCells {
+		for _, s := range a {
+			line("- ", s)
+		}
+		for _, s := range b {
+			line("+ ", s)
+		}
+		return sb.String()
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			line("  ", a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			line("- ", a[i])
+			i++
+		default:
+			line("+ ", b[j])
+			j++
+		}
+	}
+	return sb.String()
+}
+
+// testRuleSets runs the tests of the sets that have any and returns the
+// reports of those that fail
+func (r *Replacer) testRuleSets(ruleSets []RuleSet) []TestReport {
+	failures := []TestReport{}
+	for _, rs := range ruleSets {
+		if len(rs.Tests) == 0 {
+			continue
+		}
+		if report := r.TestRuleSet(rs); !report.OK() {
+			failures = append(failures, report)
+		}
+	}
+	return failures
+}
@@ -0,0 +1,87 @@
+package replacer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		actual   string
+		want     string
+	}{
+		{"equal", "a\nb", "a\nb", "  a\n  b\n"},
+		{"changed line", "a\nb\nc", "a\nB\nc", "  a\n- b\n+ B\n  c\n"},
+		{"added lines", "a\nc", "a\nb1\nb2\nc", "  a\n+ b1\n+ b2\n  c\n"},
+		{"removed lines", "a\nb\nc\nd", "a\nd", "  a\n- b\n- c\n  d\n"},
+		{"longest common run kept", "x\na\nb\nc", "a\nb\nc\nx", "- x\n  a\n  b\n  c\n+ x\n"},
+		{"trailing newline", "a\n", "a", "  a\n- \n"},
+		{"empty expected", "", "a", "- \n+ a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.expected, tt.actual); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesLargeFallsBack(t *testing.T) {
+	expected := strings.Repeat("same\n", 1024) + "old"
+	actual := strings.Repeat("same\n", 1024) + "new"
+	got := diffLines(expected, actual)
+	// Too large for the table: every expected line is removed, then every actual line added
+	if lines := strings.Split(got, "\n"); len(lines) != 2*1025+1 || lines[0] != "- same" || lines[1024] != "- old" || lines[1025] != "+ same" || lines[2049] != "+ new" {
+		t.Errorf("got %d lines starting %q", len(lines), lines[:2])
+	}
+}
+
+func TestFirstDifference(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"abc", "abd", 2},
+		{"abc", "ab", 2},
+		{"", "x", 0},
+		{"héllo", "hého", 3},
+	}
+	for _, tt := range tests {
+		if got := firstDifference(tt.a, tt.b); got != tt.want {
+			t.Errorf("firstDifference(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRuleSetTests(t *testing.T) {
+	rs := RuleSet{
+		Name:  "users",
+		Rules: []Rule{{Name: "user", Pattern: `user=(?P<value>\w+)`, Pseudonym: "user", Active: true}},
+		Tests: []RuleTest{
+			{Name: "first", Input: "user=bob user=ann", Expected: "user=user_1 user=user_2"},
+			// Every test starts from fresh state, so ann is user_1 here
+			{Name: "fresh", Input: "user=ann", Expected: "user=user_1"},
+			{Name: "wrong", Input: "a\nuser=bob\nc", Expected: "a\nuser=bob\nc"},
+		},
+	}
+	report := NewReplacer(t.TempDir()).TestRuleSet(rs)
+	if report.RuleSet != "users" || report.Passed != 2 || report.Failed != 1 || report.OK() || len(report.Results) != 3 {
+		t.Fatalf("got %+v", report)
+	}
+	if res := report.Results[0]; !res.Passed || res.Offset != -1 || res.Diff != "" {
+		t.Errorf("passing test: got %+v", res)
+	}
+	res := report.Results[2]
+	if res.Passed || res.Actual != "a\nuser=user_1\nc" || res.Offset != 7 || res.Diff != "  a\n- user=bob\n+ user=user_1\n  c\n" {
+		t.Errorf("failing test: got %+v", res)
+	}
+
+	rs.Rules = []Rule{{Name: "bad", Pattern: "(", Active: true}}
+	report = NewReplacer(t.TempDir()).TestRuleSet(rs)
+	if report.Error == "" || report.Failed != 3 || len(report.Results) != 0 || report.OK() {
+		t.Errorf("rules that do not compile: got %+v", report)
+	}
+}
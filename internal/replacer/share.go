@@ -0,0 +1,263 @@
+package replacer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rule set export files carry a format tag and a version so later releases can
+// read older files and refuse ones they do not understand
+const (
+	exportFormat  = "logana-rules"
+	ExportVersion = 1
+)
+
+// Conflict strategies for an imported set named like an existing one
+const (
+	ImportMerge     = "merge"     // Add the imported rules the existing set does not have
+	ImportRename    = "rename"    // Keep both; the imported set gets a numbered name
+	ImportOverwrite = "overwrite" // Replace the existing set
+)
+
+// ExportMeta describes a shared rule set file
+type ExportMeta struct {
+	Author      string    `json:"author,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Version     string    `json:"version,omitempty"` // Version of the rules, chosen by their author
+	Created     time.Time `json:"created"`
+}
+
+// ExportFile is the file format for sharing rule sets
+type ExportFile struct {
+	Format        string     `json:"format"`
+	FormatVersion int        `json:"formatVersion"`
+	Meta          ExportMeta `json:"meta"`
+	RuleSets      []RuleSet  `json:"ruleSets"`
+}
+
+// ImportOptions defines how shared rule sets are imported
+type ImportOptions struct {
+	Data     string `json:"data"`     // Contents of an export file, or a plain rule set or list of rule sets
+	Strategy string `json:"strategy"` // How to resolve name conflicts; empty refuses the import if there are any
+	DryRun   bool   `json:"dryRun"`   // Only validate and report conflicts
+}
+
+// ImportResult is the outcome of an import
+type ImportResult struct {
+	Meta      ExportMeta   `json:"meta"`
+	Imported  []string     `json:"imported"`  // Names of the imported sets as saved
+	Conflicts []string     `json:"conflicts"` // Imported names that were already taken
+	RuleSets  []RuleSet    `json:"ruleSets"`  // All rule sets after the import
+	Failures  []TestReport `json:"failures"`  // Imported sets whose tests fail; they are imported anyway
+}
+
+// ExportRuleSets encodes rule sets in the export file format. Created
+// defaults to now, and the sets are exported as user sets.
+func ExportRuleSets(ruleSets []RuleSet, meta ExportMeta) ([]byte, error) {
+	if len(ruleSets) == 0 {
+		return nil, fmt.Errorf("no rule set to export")
+	}
+	if meta.Created.IsZero() {
+		meta.Created = time.Now().UTC()
+	}
+	file := ExportFile{Format: exportFormat, FormatVersion: ExportVersion, Meta: meta}
+	for _, rs := range ruleSets {
+		rs.Builtin = false
+		file.RuleSets = append(file.RuleSets, rs)
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// ParseExport decodes an export file. Rule sets pasted straight from the
+// rules file, as a single set or a list, are accepted too.
+func ParseExport(data string) (ExportFile, error) {
+	var file ExportFile
+	trimmed := strings.TrimSpace(data)
+	if trimmed == "" {
+		return file, fmt.Errorf("nothing to import")
+	}
+
+	switch trimmed[0] {
+	case '[':
+		if err := json.Unmarshal([]byte(trimmed), &file.RuleSets); err != nil {
+			return file, fmt.Errorf("invalid rule sets: %v", err)
+		}
+	case '{':
+		var probe struct {
+			Format string `json:"format"`
+		}
+		if err := json.Unmarshal([]byte(trimmed), &probe); err != nil {
+			return file, fmt.Errorf("invalid rule set file: %v", err)
+		}
+		if probe.Format == "" {
+			var rs RuleSet
+			if err := json.Unmarshal([]byte(trimmed), &rs); err != nil {
+				return file, fmt.Errorf("invalid rule set: %v", err)
+			}
+			file.RuleSets = []RuleSet{rs}
+			break
+		}
+		if probe.Format != exportFormat {
+			return file, fmt.Errorf("not a rule set file (format '%s')", probe.Format)
+		}
+		if err := json.Unmarshal([]byte(trimmed), &file); err != nil {
+			return file, fmt.Errorf("invalid rule set file: %v", err)
+		}
+		if file.FormatVersion > ExportVersion {
+			return file, fmt.Errorf("rule set file version %d is newer than supported (%d); please update the app",
+				file.FormatVersion, ExportVersion)
+		}
+	default:
+		return file, fmt.Errorf("not a rule set file")
+	}
+
+	if len(file.RuleSets) == 0 {
+		return file, fmt.Errorf("no rule set to import")
+	}
+	for i, rs := range file.RuleSets {
+		if strings.TrimSpace(rs.Name) == "" {
+			return file, fmt.Errorf("rule set #%d has no name", i+1)
+		}
+	}
+	return file, nil
+}
+
+// validateAll compiles every rule of a set, including inactive ones
+func validateAll(rs RuleSet) error {
+	rules := make([]Rule, len(rs.Rules))
+	for i, rule := range rs.Rules {
+		rule.Active = true
+		rules[i] = rule
+	}
+	if _, err := Compile(rules); err != nil {
+		return fmt.Errorf("rule set '%s': %v", rs.Name, err)
+	}
+	return nil
+}
+
+// ImportRuleSets validates shared rule sets and adds them to the saved ones.
+// Nothing is saved if any rule fails to compile or if names conflict and no
+// strategy is given.
+func (r *Replacer) ImportRuleSets(opts ImportOptions) (ImportResult, error) {
+	result := ImportResult{Imported: []string{}, Conflicts: []string{}, Failures: []TestReport{}}
+	switch opts.Strategy {
+	case "", ImportMerge, ImportRename, ImportOverwrite:
+	default:
+		return result, fmt.Errorf("unknown import strategy '%s'", opts.Strategy)
+	}
+
+	file, err := ParseExport(opts.Data)
+	if err != nil {
+		return result, err
+	}
+	result.Meta = file.Meta
+	for _, rs := range file.RuleSets {
+		if err := validateAll(rs); err != nil {
+			return result, err
+		}
+	}
+
+	existing, _, err := r.LoadRuleSets()
+	if err != nil {
+		return result, err
+	}
+	sets := append([]RuleSet{}, existing...)
+	index := func(name string) int {
+		for i, rs := range sets {
+			if rs.Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for _, rs := range file.RuleSets {
+		rs.Builtin = false
+		i := index(rs.Name)
+		if i < 0 {
+			sets = append(sets, rs)
+			result.Imported = append(result.Imported, rs.Name)
+			continue
+		}
+
+		result.Conflicts = append(result.Conflicts, rs.Name)
+		switch opts.Strategy {
+		case ImportMerge:
+			sets[i].Rules = mergeRules(sets[i].Rules, rs.Rules)
+			for _, test := range rs.Tests {
+				if !containsTest(sets[i].Tests, test) {
+					sets[i].Tests = append(sets[i].Tests, test)
+				}
+			}
+		case ImportRename:
+			base := rs.Name
+			for n := 2; index(rs.Name) >= 0; n++ {
+				rs.Name = fmt.Sprintf("%s (%d)", base, n)
+			}
+			sets = append(sets, rs)
+		case ImportOverwrite:
+			sets[i] = rs
+		}
+		result.Imported = append(result.Imported, rs.Name)
+	}
+
+	if len(result.Conflicts) > 0 && opts.Strategy == "" {
+		result.Imported = []string{}
+		if opts.DryRun {
+			result.RuleSets = existing
+			return result, nil
+		}
+		return result, fmt.Errorf("rule sets already exist: %s; choose merge, rename or overwrite",
+			strings.Join(result.Conflicts, ", "))
+	}
+
+	result.RuleSets = sets
+	if opts.DryRun {
+		result.Failures = r.testRuleSets(file.RuleSets)
+		return result, nil
+	}
+	failures, err := r.SaveRuleSets(sets)
+	if err != nil {
+		return result, err
+	}
+	// Only the imported sets are reported; failures of the others are not news
+	for _, report := range failures {
+		for _, name := range result.Imported {
+			if report.RuleSet == name {
+				result.Failures = append(result.Failures, report)
+			}
+		}
+	}
+	return result, nil
+}
+
+// mergeRules appends the imported rules that are not already in the set. Rules
+// are compared without their UI state.
+func mergeRules(rules, imported []Rule) []Rule {
+	merged := append([]Rule{}, rules...)
+	for _, rule := range imported {
+		dup := false
+		for _, have := range rules {
+			have.Collapsed = rule.Collapsed
+			if have == rule {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			merged = append(merged, rule)
+		}
+	}
+	return merged
+}
+
+func containsTest(tests []RuleTest, test RuleTest) bool {
+	for _, t := range tests {
+		if t == test {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+package replacer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var (
+	maskIP     = Rule{Name: "ip", Pattern: `\d+\.\d+\.\d+\.\d+`, Replacement: "<IP>", Active: true}
+	maskNumber = Rule{Name: "number", Pattern: `\d+`, Replacement: "<N>", Active: true}
+	maskEmail  = Rule{Name: "email", Pattern: `\S+@\S+`, Replacement: "<EMAIL>", Active: true}
+)
+
+// newShareReplacer returns a replacer whose saved rule sets are "mask" and "keep"
+func newShareReplacer(t *testing.T) *Replacer {
+	t.Helper()
+	r := NewReplacer(t.TempDir())
+	_, err := r.SaveRuleSets([]RuleSet{
+		{Name: "mask", Rules: []Rule{maskIP}, Tests: []RuleTest{{Input: "from 10.0.0.1", Expected: "from <IP>"}}},
+		{Name: "keep", Rules: []Rule{maskEmail}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func exportData(t *testing.T, sets ...RuleSet) string {
+	t.Helper()
+	data, err := ExportRuleSets(sets, ExportMeta{Author: "ops", Version: "1.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func setNames(sets []RuleSet) string {
+	names := make([]string, len(sets))
+	for i, rs := range sets {
+		names[i] = rs.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func findSet(t *testing.T, sets []RuleSet, name string) RuleSet {
+	t.Helper()
+	for _, rs := range sets {
+		if rs.Name == name {
+			return rs
+		}
+	}
+	t.Fatalf("no rule set '%s' in %s", name, setNames(sets))
+	return RuleSet{}
+}
+
+func TestImportConflicts(t *testing.T) {
+	collapsedIP := maskIP
+	collapsedIP.Collapsed = true
+	imported := RuleSet{
+		Name:    "mask",
+		Rules:   []Rule{collapsedIP, maskNumber}, // The IP rule differs only in UI state
+		Tests:   []RuleTest{{Input: "from 10.0.0.1", Expected: "from <IP>"}, {Input: "pid 42", Expected: "pid <N>"}},
+		Builtin: true,
+	}
+	fresh := RuleSet{Name: "fresh", Rules: []Rule{maskNumber}}
+
+	tests := []struct {
+		name     string
+		strategy string
+		imported string // Names reported as imported
+		sets     string // Names of all sets after the import
+		rules    map[string][]string
+		tests    map[string]int
+	}{
+		{"merge", ImportMerge, "fresh,mask", "mask,keep,fresh",
+			map[string][]string{"mask": {"ip", "number"}}, map[string]int{"mask": 2}},
+		{"rename", ImportRename, "fresh,mask (2)", "mask,keep,fresh,mask (2)",
+			map[string][]string{"mask": {"ip"}, "mask (2)": {"ip", "number"}}, map[string]int{"mask": 1, "mask (2)": 2}},
+		{"overwrite", ImportOverwrite, "fresh,mask", "mask,keep,fresh",
+			map[string][]string{"mask": {"ip", "number"}}, map[string]int{"mask": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newShareReplacer(t)
+			result, err := r.ImportRuleSets(ImportOptions{Data: exportData(t, fresh, imported), Strategy: tt.strategy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(result.Imported, ","); got != tt.imported {
+				t.Errorf("imported %s, want %s", got, tt.imported)
+			}
+			if got := strings.Join(result.Conflicts, ","); got != "mask" {
+				t.Errorf("conflicts %s, want mask", got)
+			}
+			if result.Meta.Author != "ops" || result.Meta.Version != "1.2" {
+				t.Errorf("got meta %+v", result.Meta)
+			}
+
+			saved, _, err := r.LoadRuleSets()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := setNames(saved); got != tt.sets || setNames(result.RuleSets) != tt.sets {
+				t.Errorf("saved %s, result %s, want %s", got, setNames(result.RuleSets), tt.sets)
+			}
+			for name, want := range tt.rules {
+				rs := findSet(t, saved, name)
+				var got []string
+				for _, rule := range rs.Rules {
+					got = append(got, rule.Name)
+				}
+				if strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Errorf("set %s has rules %v, want %v", name, got, want)
+				}
+				if rs.Builtin {
+					t.Errorf("set %s was imported as built-in", name)
+				}
+				if len(rs.Tests) != tt.tests[name] {
+					t.Errorf("set %s has %d tests, want %d", name, len(rs.Tests), tt.tests[name])
+				}
+			}
+		})
+	}
+}
+
+func TestImportRenameSkipsTakenNames(t *testing.T) {
+	r := newShareReplacer(t)
+	for _, want := range []string{"mask (2)", "mask (3)"} {
+		result, err := r.ImportRuleSets(ImportOptions{Data: exportData(t, RuleSet{Name: "mask", Rules: []Rule{maskNumber}}), Strategy: ImportRename})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Imported) != 1 || result.Imported[0] != want {
+			t.Errorf("imported %v, want %s", result.Imported, want)
+		}
+	}
+}
+
+func TestImportWithoutStrategy(t *testing.T) {
+	r := newShareReplacer(t)
+	data := exportData(t, RuleSet{Name: "fresh", Rules: []Rule{maskNumber}}, RuleSet{Name: "keep", Rules: []Rule{maskNumber}})
+
+	result, err := r.ImportRuleSets(ImportOptions{Data: data, DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run reports conflicts without failing: %v", err)
+	}
+	if len(result.Imported) != 0 || strings.Join(result.Conflicts, ",") != "keep" || setNames(result.RuleSets) != "mask,keep" {
+		t.Errorf("dry run: imported %v, conflicts %v, sets %s", result.Imported, result.Conflicts, setNames(result.RuleSets))
+	}
+
+	result, err = r.ImportRuleSets(ImportOptions{Data: data})
+	if err == nil || !strings.Contains(err.Error(), "rule sets already exist: keep") {
+		t.Errorf("got %v, want a conflict error", err)
+	}
+	if len(result.Imported) != 0 {
+		t.Errorf("imported %v despite the conflict", result.Imported)
+	}
+	saved, _, _ := r.LoadRuleSets()
+	if setNames(saved) != "mask,keep" {
+		t.Errorf("conflicting import saved %s", setNames(saved))
+	}
+}
+
+func TestImportDryRunAndFailures(t *testing.T) {
+	r := newShareReplacer(t)
+	failing := RuleSet{Name: "fresh", Rules: []Rule{maskNumber}, Tests: []RuleTest{{Input: "pid 42", Expected: "pid 42"}}}
+
+	result, err := r.ImportRuleSets(ImportOptions{Data: exportData(t, failing), DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failures) != 1 || result.Failures[0].RuleSet != "fresh" {
+		t.Errorf("dry run failures: %+v", result.Failures)
+	}
+	if saved, _, _ := r.LoadRuleSets(); setNames(saved) != "mask,keep" {
+		t.Errorf("dry run saved %s", setNames(saved))
+	}
+
+	// Sets with failing tests are imported anyway; only theirs are reported
+	result, err = r.ImportRuleSets(ImportOptions{Data: exportData(t, failing)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failures) != 1 || result.Failures[0].RuleSet != "fresh" {
+		t.Errorf("import failures: %+v", result.Failures)
+	}
+	if saved, _, _ := r.LoadRuleSets(); setNames(saved) != "mask,keep,fresh" {
+		t.Errorf("saved %s", setNames(saved))
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	broken := RuleSet{Name: "broken", Rules: []Rule{maskNumber, {Name: "bad", Pattern: `(`, Active: false}}}
+	newer, _ := json.Marshal(ExportFile{Format: exportFormat, FormatVersion: ExportVersion + 1, RuleSets: []RuleSet{{Name: "x"}}})
+
+	tests := []struct {
+		name string
+		opts ImportOptions
+		want string
+	}{
+		{"unknown strategy", ImportOptions{Data: `{"name": "x"}`, Strategy: "replace"}, "unknown import strategy"},
+		{"empty", ImportOptions{Data: "  "}, "nothing to import"},
+		{"inactive rule does not compile", ImportOptions{Data: exportData(t, broken)}, "rule set 'broken'"},
+		{"newer file", ImportOptions{Data: string(newer)}, "newer than supported"},
+		{"other format", ImportOptions{Data: `{"format": "something-else"}`}, "not a rule set file"},
+		{"unnamed set", ImportOptions{Data: `[{"name": "a"}, {"name": " "}]`}, "rule set #2 has no name"},
+		{"not json", ImportOptions{Data: "mask: on"}, "not a rule set file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newShareReplacer(t)
+			if _, err := r.ImportRuleSets(tt.opts); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+			if saved, _, _ := r.LoadRuleSets(); setNames(saved) != "mask,keep" {
+				t.Errorf("rejected import saved %s", setNames(saved))
+			}
+		})
+	}
+}
+
+func TestParseExportPlainSets(t *testing.T) {
+	for _, data := range []string{`{"name": "one", "rules": []}`, `[{"name": "one"}]`} {
+		file, err := ParseExport(data)
+		if err != nil {
+			t.Errorf("%s: %v", data, err)
+			continue
+		}
+		if setNames(file.RuleSets) != "one" {
+			t.Errorf("%s: got sets %s", data, setNames(file.RuleSets))
+		}
+	}
+}
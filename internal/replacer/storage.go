@@ -0,0 +1,226 @@
+package replacer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"logana/internal/fileutil"
+)
+
+// Rule storage keeps the previous save in <file>.bak and, at most every
+// versionInterval, a copy of it in the history directory, so an editing
+// session leaves a few restorable versions rather than one per keystroke
+const (
+	maxVersions     = 20
+	versionInterval = 5 * time.Minute
+	versionLayout   = "20060102-150405.000"
+	backupID        = "backup"
+)
+
+// RuleVersion is a saved earlier version of the rule sets
+type RuleVersion struct {
+	ID       string    `json:"id"`
+	Saved    time.Time `json:"saved"` // When this version was saved, not when it was replaced
+	RuleSets int       `json:"ruleSets"`
+	Rules    int       `json:"rules"`
+}
+
+func (r *Replacer) backupPath() string {
+	return r.configPath + ".bak"
+}
+
+func (r *Replacer) historyDir() string {
+	return strings.TrimSuffix(r.configPath, filepath.Ext(r.configPath)) + ".history"
+}
+
+// parseRuleSets decodes a rules file
+func parseRuleSets(data []byte) ([]RuleSet, error) {
+	var ruleSets []RuleSet
+	if err := json.Unmarshal(data, &ruleSets); err != nil {
+		return nil, err
+	}
+	if ruleSets == nil {
+		ruleSets = []RuleSet{}
+	}
+	return ruleSets, nil
+}
+
+// keepPrevious backs up the current rules file before it is replaced by data.
+// A corrupt or unchanged file is not backed up.
+func (r *Replacer) keepPrevious(data []byte) error {
+	info, err := os.Stat(r.configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	old, err := os.ReadFile(r.configPath)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(old, data) {
+		return nil
+	}
+	if _, err := parseRuleSets(old); err != nil {
+		return nil
+	}
+
+	saved := info.ModTime().UTC()
+	if err := fileutil.WriteAtomic(r.backupPath(), old, 0o644); err != nil {
+		return err
+	}
+	os.Chtimes(r.backupPath(), saved, saved)
+
+	versions, err := r.historyFiles()
+	if err != nil {
+		return err
+	}
+	if len(versions) > 0 {
+		if last, err := time.Parse(versionLayout, strings.TrimSuffix(versions[0], ".json")); err == nil && saved.Sub(last) < versionInterval {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(r.historyDir(), 0o755); err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(filepath.Join(r.historyDir(), saved.Format(versionLayout)+".json"), old, 0o644); err != nil {
+		return err
+	}
+	versions, err = r.historyFiles()
+	if err != nil {
+		return err
+	}
+	for _, name := range versions[min(len(versions), maxVersions):] {
+		os.Remove(filepath.Join(r.historyDir(), name))
+	}
+	return nil
+}
+
+// historyFiles lists the file names in the history directory, newest first
+func (r *Replacer) historyFiles() ([]string, error) {
+	entries, err := os.ReadDir(r.historyDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
+
+// versionPath resolves a RuleVersion ID to its file
+func (r *Replacer) versionPath(id string) (string, error) {
+	if id == backupID {
+		return r.backupPath(), nil
+	}
+	if id == "" || filepath.Base(id) != id || !strings.HasSuffix(id, ".json") {
+		return "", fmt.Errorf("unknown rule version '%s'", id)
+	}
+	return filepath.Join(r.historyDir(), id), nil
+}
+
+// ListRuleVersions returns the restorable versions, newest first: the
+// previous save, then the history. Unreadable versions are left out.
+func (r *Replacer) ListRuleVersions() ([]RuleVersion, error) {
+	versions := []RuleVersion{}
+	add := func(id string, saved time.Time) {
+		path, err := r.versionPath(id)
+		if err != nil {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		ruleSets, err := parseRuleSets(data)
+		if err != nil {
+			return
+		}
+		v := RuleVersion{ID: id, Saved: saved, RuleSets: len(ruleSets)}
+		for _, rs := range ruleSets {
+			v.Rules += len(rs.Rules)
+		}
+		versions = append(versions, v)
+	}
+
+	if info, err := os.Stat(r.backupPath()); err == nil {
+		add(backupID, info.ModTime().UTC())
+	}
+	names, err := r.historyFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		saved, err := time.Parse(versionLayout, strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		add(name, saved)
+	}
+	return versions, nil
+}
+
+// RestoreRuleVersion saves an earlier version as the current rule sets. The
+// rules it replaces are backed up like any other save.
+func (r *Replacer) RestoreRuleVersion(id string) ([]RuleSet, error) {
+	path, err := r.versionPath(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("unknown rule version '%s'", id)
+		}
+		return nil, err
+	}
+	ruleSets, err := parseRuleSets(data)
+	if err != nil {
+		return nil, fmt.Errorf("rule version '%s' is corrupt: %v", id, err)
+	}
+	if _, err := r.SaveRuleSets(ruleSets); err != nil {
+		return nil, err
+	}
+	return ruleSets, nil
+}
+
+// recoverRuleSets puts back the newest valid version after the rules file
+// failed to load. The corrupt file is kept as <file>.corrupt for inspection.
+func (r *Replacer) recoverRuleSets(cause error) ([]RuleSet, string, error) {
+	versions, err := r.ListRuleVersions()
+	if err != nil || len(versions) == 0 {
+		return nil, "", cause
+	}
+	path, _ := r.versionPath(versions[0].ID)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", cause
+	}
+	ruleSets, err := parseRuleSets(data)
+	if err != nil {
+		return nil, "", cause
+	}
+
+	if corrupt, err := os.ReadFile(r.configPath); err == nil {
+		if err := fileutil.WriteAtomic(r.configPath+".corrupt", corrupt, 0o644); err == nil {
+			fileutil.WriteAtomic(r.configPath, data, 0o644)
+		}
+	}
+	warning := fmt.Sprintf("%s could not be read (%v); loaded the version saved at %s instead",
+		filepath.Base(r.configPath), cause, versions[0].Saved.Local().Format("2006-01-02 15:04:05"))
+	return ruleSets, warning, nil
+}
@@ -0,0 +1,210 @@
+package replacer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ruleSets returns n distinct rule sets, so every save changes the file
+func ruleSets(n int) []RuleSet {
+	sets := make([]RuleSet, n)
+	for i := range sets {
+		sets[i] = RuleSet{Name: fmt.Sprintf("set %d", i+1), Rules: []Rule{{Name: "digits", Pattern: `\d+`, Replacement: "<N>", Active: true}}}
+	}
+	return sets
+}
+
+// saveAt saves rule sets after dating the current file at t, which names its history entry
+func saveAt(t *testing.T, r *Replacer, sets []RuleSet, at time.Time) {
+	t.Helper()
+	if !at.IsZero() {
+		if err := os.Chtimes(r.configPath, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.SaveRuleSets(sets); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadCount(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets, err := parseRuleSets(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(sets)
+}
+
+func TestSaveKeepsBackup(t *testing.T) {
+	r := NewReplacer(t.TempDir())
+
+	saveAt(t, r, ruleSets(1), time.Time{})
+	if _, err := os.Stat(r.backupPath()); !os.IsNotExist(err) {
+		t.Fatalf("first save left a backup: %v", err)
+	}
+
+	saveAt(t, r, ruleSets(2), time.Time{})
+	if n := loadCount(t, r.backupPath()); n != 1 {
+		t.Errorf("backup has %d sets, want the previous save with 1", n)
+	}
+
+	// Saving the same sets again keeps the backup of the earlier version
+	saveAt(t, r, ruleSets(2), time.Time{})
+	if n := loadCount(t, r.backupPath()); n != 1 {
+		t.Errorf("unchanged save replaced the backup: it has %d sets", n)
+	}
+
+	versions, err := r.ListRuleVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) == 0 || versions[0].ID != backupID || versions[0].RuleSets != 1 || versions[0].Rules != 1 {
+		t.Errorf("got versions %+v, want the backup first", versions)
+	}
+}
+
+func TestSaveSkipsCorruptBackup(t *testing.T) {
+	r := NewReplacer(t.TempDir())
+	saveAt(t, r, ruleSets(1), time.Time{})
+	saveAt(t, r, ruleSets(2), time.Time{})
+	if err := os.WriteFile(r.configPath, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	saveAt(t, r, ruleSets(3), time.Time{})
+	if n := loadCount(t, r.backupPath()); n != 1 {
+		t.Errorf("backup has %d sets; the corrupt file must not replace it", n)
+	}
+}
+
+func TestHistoryInterval(t *testing.T) {
+	r := NewReplacer(t.TempDir())
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	saveAt(t, r, ruleSets(1), time.Time{})
+	saveAt(t, r, ruleSets(2), base)
+	saveAt(t, r, ruleSets(3), base.Add(time.Minute))     // Too soon after the last version
+	saveAt(t, r, ruleSets(4), base.Add(versionInterval)) // Due again
+
+	names, err := r.historyFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{base.Add(versionInterval).Format(versionLayout) + ".json", base.Format(versionLayout) + ".json"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("got history %v, want %v", names, want)
+	}
+	if n := loadCount(t, filepath.Join(r.historyDir(), want[0])); n != 3 {
+		t.Errorf("newest history entry has %d sets, want 3", n)
+	}
+}
+
+func TestHistoryRotation(t *testing.T) {
+	r := NewReplacer(t.TempDir())
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	saveAt(t, r, ruleSets(1), time.Time{})
+	for i := 1; i <= maxVersions+5; i++ {
+		saveAt(t, r, ruleSets(i+1), base.Add(time.Duration(i)*versionInterval))
+	}
+
+	names, err := r.historyFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != maxVersions {
+		t.Fatalf("got %d history entries, want %d", len(names), maxVersions)
+	}
+	if newest := base.Add((maxVersions + 5) * versionInterval).Format(versionLayout); names[0] != newest+".json" {
+		t.Errorf("newest entry is %s, want %s", names[0], newest)
+	}
+	if oldest := base.Add(6 * versionInterval).Format(versionLayout); names[len(names)-1] != oldest+".json" {
+		t.Errorf("oldest entry is %s, want %s", names[len(names)-1], oldest)
+	}
+
+	versions, err := r.ListRuleVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != maxVersions+1 {
+		t.Errorf("got %d versions, want the backup and %d history entries", len(versions), maxVersions)
+	}
+}
+
+func TestRestoreRuleVersion(t *testing.T) {
+	r := NewReplacer(t.TempDir())
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	saveAt(t, r, ruleSets(1), time.Time{})
+	saveAt(t, r, ruleSets(2), base)
+	saveAt(t, r, ruleSets(3), base.Add(versionInterval))
+
+	id := base.Format(versionLayout) + ".json"
+	restored, err := r.RestoreRuleVersion(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || loadCount(t, r.configPath) != 1 {
+		t.Errorf("restored %d sets, file has %d; want the first save", len(restored), loadCount(t, r.configPath))
+	}
+	if n := loadCount(t, r.backupPath()); n != 3 {
+		t.Errorf("backup has %d sets, want the replaced save with 3", n)
+	}
+
+	for _, bad := range []string{"", "missing.json", "../regex_rules.json", "notes.txt"} {
+		if _, err := r.RestoreRuleVersion(bad); err == nil || !strings.Contains(err.Error(), "unknown rule version") {
+			t.Errorf("restore %q: got %v, want an unknown version error", bad, err)
+		}
+	}
+}
+
+func TestLoadRecoversCorruptFile(t *testing.T) {
+	r := NewReplacer(t.TempDir())
+	saveAt(t, r, ruleSets(1), time.Time{})
+	saveAt(t, r, ruleSets(2), time.Time{})
+
+	corrupt := []byte(`[{"name": "set 1", "rules": [`)
+	if err := os.WriteFile(r.configPath, corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sets, warning, err := r.LoadRuleSets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 || warning == "" {
+		t.Errorf("got %d sets and warning %q, want the backup with 1 set and a warning", len(sets), warning)
+	}
+	if kept, err := os.ReadFile(r.configPath + ".corrupt"); err != nil || string(kept) != string(corrupt) {
+		t.Errorf("corrupt file not kept: %q, %v", kept, err)
+	}
+	if n := loadCount(t, r.configPath); n != 1 {
+		t.Errorf("rules file has %d sets after recovery, want 1", n)
+	}
+
+	// Loading again finds a valid file
+	if _, warning, err := r.LoadRuleSets(); err != nil || warning != "" {
+		t.Errorf("second load: warning %q, error %v", warning, err)
+	}
+}
+
+func TestLoadCorruptFileWithoutVersions(t *testing.T) {
+	r := NewReplacer(t.TempDir())
+	if err := os.WriteFile(r.configPath, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.LoadRuleSets(); err == nil {
+		t.Error("want the parse error when there is nothing to recover")
+	}
+	if _, err := os.Stat(r.configPath + ".corrupt"); !os.IsNotExist(err) {
+		t.Errorf("corrupt copy written without a recovery: %v", err)
+	}
+}
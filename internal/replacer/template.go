@@ -0,0 +1,231 @@
+package replacer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Template expressions in replacements take the form ${ref|func|func...}:
+// ref is a group number or name, and each function transforms the output of
+// the previous one, e.g. ${1|lower|hash}. ${name|inc} is a counter instead
+// of a group: it yields 1, 2, 3... on successive replacements. Replacements
+// without such expressions keep their plain $1 / ${name} expansion.
+
+// templateFuncs are the functions available in template expressions
+var templateFuncs = map[string]func(string) string{
+	"upper":        strings.ToUpper,
+	"lower":        strings.ToLower,
+	"hash":         hashValue,
+	"base64encode": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"base64decode": base64Decode,
+	"unix2iso":     unixToISO,
+}
+
+// funcInc names the counter function, which takes no input
+const funcInc = "inc"
+
+// template is a replacement split into plain parts and template expressions
+type template struct {
+	parts []templatePart
+}
+
+// templatePart is either plain text expanded with $1-style references, or an expression
+type templatePart struct {
+	text    string // Plain text; empty for an expression
+	expr    bool
+	group   int    // Submatch index of the reference
+	counter string // Counter name for ${name|inc}
+	funcs   []func(string) string
+}
+
+// templateError is a malformed template expression
+type templateError struct {
+	pos int // Byte offset of the expression in the replacement
+	msg string
+}
+
+func (e *templateError) Error() string {
+	return e.msg
+}
+
+// parseTemplate parses the template expressions of a replacement. It returns
+// nil if there are none, so the replacement is expanded as before.
+func parseTemplate(repl string, re *regexp.Regexp) (*template, error) {
+	t := &template{}
+	plain := 0
+	for i := 0; i < len(repl); i++ {
+		if repl[i] != '$' || i+1 == len(repl) {
+			continue
+		}
+		if repl[i+1] == '$' {
+			i++ // Escaped dollar, left for the plain expansion
+			continue
+		}
+		if repl[i+1] != '{' {
+			continue
+		}
+
+		end := strings.IndexByte(repl[i:], '}')
+		if end < 0 {
+			if strings.Contains(repl[i:], "|") {
+				return nil, &templateError{pos: i, msg: "unclosed '${'"}
+			}
+			break
+		}
+		end += i
+		inner := repl[i+2 : end]
+		if !strings.Contains(inner, "|") {
+			i = end // A plain ${name} reference
+			continue
+		}
+
+		part, err := parseExpr(inner, re)
+		if err != nil {
+			return nil, &templateError{pos: i, msg: fmt.Sprintf("'%s': %s", repl[i:end+1], err)}
+		}
+		if plain < i {
+			t.parts = append(t.parts, templatePart{text: repl[plain:i]})
+		}
+		t.parts = append(t.parts, part)
+		plain = end + 1
+		i = end
+	}
+
+	if len(t.parts) == 0 {
+		return nil, nil
+	}
+	if plain < len(repl) {
+		t.parts = append(t.parts, templatePart{text: repl[plain:]})
+	}
+	return t, nil
+}
+
+// parseExpr parses the inside of ${ref|func...}
+func parseExpr(inner string, re *regexp.Regexp) (templatePart, error) {
+	part := templatePart{expr: true}
+	fields := strings.Split(inner, "|")
+	ref := strings.TrimSpace(fields[0])
+	if ref == "" {
+		return part, fmt.Errorf("missing group before '|'")
+	}
+
+	for i, field := range fields[1:] {
+		name := strings.TrimSpace(field)
+		switch {
+		case name == "":
+			return part, fmt.Errorf("empty function name")
+		case name == funcInc:
+			if i > 0 {
+				return part, fmt.Errorf("'%s' must be the first function", funcInc)
+			}
+			part.counter = ref
+		case templateFuncs[name] != nil:
+			part.funcs = append(part.funcs, templateFuncs[name])
+		default:
+			return part, fmt.Errorf("unknown function '%s' (available: %s)", name, strings.Join(TemplateFuncs(), ", "))
+		}
+	}
+	if part.counter != "" {
+		return part, nil
+	}
+
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 0 || n > re.NumSubexp() {
+			return part, fmt.Errorf("no group %d in the pattern", n)
+		}
+		part.group = n
+		return part, nil
+	}
+	if part.group = re.SubexpIndex(ref); part.group < 0 {
+		return part, fmt.Errorf("no group named '%s' in the pattern", ref)
+	}
+	return part, nil
+}
+
+// TemplateFuncs returns the names of the functions usable in replacement templates
+func TemplateFuncs() []string {
+	names := []string{funcInc}
+	for name := range templateFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expand appends the replacement for one match to dst
+func (t *template) expand(dst []byte, re *regexp.Regexp, text string, loc []int, state *runState) []byte {
+	for _, part := range t.parts {
+		if !part.expr {
+			dst = re.ExpandString(dst, part.text, text, loc)
+			continue
+		}
+
+		var value string
+		switch {
+		case part.counter != "":
+			value = strconv.Itoa(state.next(part.counter))
+		case loc[2*part.group] >= 0:
+			value = text[loc[2*part.group]:loc[2*part.group+1]]
+		}
+		for _, fn := range part.funcs {
+			value = fn(value)
+		}
+		dst = append(dst, value...)
+	}
+	return dst
+}
+
+// hashValue returns a short stable digest, enough to tell values apart without revealing them
+func hashValue(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+// base64Decode accepts padded, unpadded and URL-safe input; invalid input is left unchanged
+func base64Decode(s string) string {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return string(b)
+		}
+	}
+	return s
+}
+
+// unixToISO converts a Unix timestamp to RFC 3339 in UTC. The unit (seconds,
+// milliseconds, microseconds or nanoseconds) follows from the magnitude, and
+// fractional seconds are accepted. Anything else is left unchanged.
+func unixToISO(s string) string {
+	if strings.Contains(s, ".") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return s
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e6))*1e3).UTC().Format(time.RFC3339Nano)
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return s
+	}
+	var t time.Time
+	switch {
+	case n < 1e11:
+		t = time.Unix(n, 0)
+	case n < 1e14:
+		t = time.UnixMilli(n)
+	case n < 1e17:
+		t = time.UnixMicro(n)
+	default:
+		t = time.Unix(0, n)
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
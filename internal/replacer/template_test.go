@@ -0,0 +1,100 @@
+package replacer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		replacement string
+		input       string
+		want        string
+	}{
+		{"plain references unchanged", `(\w+)=(?P<v>\w+)`, "$1:${v} $$", "a=b", "a:b $"},
+		{"upper and lower", `(\w+) (?P<last>\w+)`, "${1|upper} ${last|lower}", "ada LOVELACE", "ADA lovelace"},
+		{"functions chain", `user=(\w+)`, "user=${1|lower|hash}", "user=Bob user=bob", "user=81b637d8fcd2 user=81b637d8fcd2"},
+		{"whole match", `\w+`, "<${0|upper}>", "ab cd", "<AB> <CD>"},
+		{"spaces around names", `(\w+)`, "${ 1 | upper }", "x", "X"},
+		{"mixed with plain references", `(\w+)@(\w+)`, "$2/${1|upper}/$1", "ann@host", "host/ANN/ann"},
+		{"unmatched group is empty", `a(b)?`, "[${1|upper}]", "a ab", "[] [B]"},
+		{"counter", `item`, "item${n|inc}", "item item item", "item1 item2 item3"},
+		{"counter then function", `x`, "${n|inc|base64encode}", "x x", "MQ== Mg=="},
+		{"base64 round trip", `(\S+)`, "${1|base64encode|base64decode}", "héllo", "héllo"},
+		{"base64 decode variants", `(\S+)`, "${1|base64decode}", "aGk= aGk aGk_ !!", "hi hi hi? !!"},
+		{"unix seconds", `(\S+)`, "${1|unix2iso}", "1700000000", "2023-11-14T22:13:20Z"},
+		{"unix milliseconds", `(\S+)`, "${1|unix2iso}", "1700000000123", "2023-11-14T22:13:20.123Z"},
+		{"unix microseconds", `(\S+)`, "${1|unix2iso}", "1700000000123456", "2023-11-14T22:13:20.123456Z"},
+		{"unix nanoseconds", `(\S+)`, "${1|unix2iso}", "1700000000123456789", "2023-11-14T22:13:20.123456789Z"},
+		{"fractional seconds", `(\S+)`, "${1|unix2iso}", "1700000000.5", "2023-11-14T22:13:20.5Z"},
+		{"not a timestamp", `(\S+)`, "${1|unix2iso}", "soon", "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Compile([]Rule{{Name: tt.name, Pattern: tt.pattern, Replacement: tt.replacement, Active: true}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Replace(tt.input); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateCountersPersist(t *testing.T) {
+	c, err := Compile([]Rule{
+		{Name: "a", Pattern: `a`, Replacement: "a${n|inc}", Active: true},
+		{Name: "b", Pattern: `b`, Replacement: "b${n|inc}", Active: true},
+		{Name: "c", Pattern: `c`, Replacement: "c${m|inc}", Active: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Counters with the same name are shared by the rules and kept between calls
+	if got := c.Replace("a b c"); got != "a1 b2 c1" {
+		t.Errorf("first call: got %q", got)
+	}
+	if got := c.Replace("a b c"); got != "a3 b4 c2" {
+		t.Errorf("second call: got %q", got)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	tests := []struct {
+		replacement string
+		position    int
+		message     string
+	}{
+		{"x ${1|shout}", 2, "unknown function 'shout'"},
+		{"${|upper}", 0, "missing group"},
+		{"${1|}", 0, "empty function name"},
+		{"${1|upper|inc}", 0, "'inc' must be the first function"},
+		{"${3|upper}", 0, "no group 3"},
+		{"${name|upper}", 0, "no group named 'name'"},
+		{"ab ${1|upper", 3, "unclosed '${'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.replacement, func(t *testing.T) {
+			errs := Validate([]Rule{{Name: "r", Pattern: `(\w+)`, Replacement: tt.replacement, Active: true}})
+			if len(errs) != 1 {
+				t.Fatalf("got %v, want one error", errs)
+			}
+			if e := errs[0]; e.Field != FieldReplacement || e.Position != tt.position || !strings.Contains(e.Message, tt.message) {
+				t.Errorf("got field %q position %d message %q, want position %d and %q", e.Field, e.Position, e.Message, tt.position, tt.message)
+			}
+		})
+	}
+
+	// Literal and pseudonym rules do not parse their replacement as a template
+	for _, rule := range []Rule{
+		{Name: "literal", Pattern: "x", Replacement: "${1|shout}", Literal: true, Active: true},
+		{Name: "pseudonym", Pattern: "x", Replacement: "${1|shout}", Pseudonym: "p", Active: true},
+	} {
+		if errs := Validate([]Rule{rule}); len(errs) != 0 {
+			t.Errorf("%s: got %v", rule.Name, errs)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package replacer
+
+import "unicode/utf8"
+
+// Span locates text by byte offsets, with code point offsets for the UI
+type Span struct {
+	Start     int `json:"start"`
+	End       int `json:"end"`
+	RuneStart int `json:"runeStart"`
+	RuneEnd   int `json:"runeEnd"`
+}
+
+// TraceMatch is one match of a rule: where it was in the text before the rule
+// ran and where its replacement is in the text after
+type TraceMatch struct {
+	Input  Span `json:"input"`
+	Output Span `json:"output"`
+}
+
+// TraceStep records what one rule did
+type TraceStep struct {
+	Index   int          `json:"index"` // Position of the rule in the set
+	Name    string       `json:"name"`
+	Skipped bool         `json:"skipped,omitempty"` // The rule is inactive
+	Count   int          `json:"count"`             // Matches replaced
+	Matches []TraceMatch `json:"matches"`
+	Text    string       `json:"text"` // Text after the rule
+}
+
+// Trace is the step-by-step outcome of applying a rule set
+type Trace struct {
+	Input  string      `json:"input"`
+	Output string      `json:"output"`
+	Steps  []TraceStep `json:"steps"`
+}
+
+// runeCounter converts increasing byte offsets of a string to code point offsets
+type runeCounter struct {
+	text  string
+	bytes int
+	runes int
+}
+
+func (rc *runeCounter) at(offset int) int {
+	rc.runes += utf8.RuneCountInString(rc.text[rc.bytes:offset])
+	rc.bytes = offset
+	return rc.runes
+}
+
+// ReplaceTrace applies the rules like Replace and records, for every rule,
+// the intermediate text, the number of matches and the span of each match in
+// the rule's input and output
+func (r *Replacer) ReplaceTrace(text string, rules []Rule) (Trace, error) {
+	trace := Trace{Input: text, Steps: []TraceStep{}}
+
+	c, err := Compile(rules)
+	if err != nil {
+		return trace, err
+	}
+
+	active := c.rules
+	for i, rule := range rules {
+		step := TraceStep{Index: i, Name: rule.Name, Matches: []TraceMatch{}}
+		if !rule.Active {
+			step.Skipped = true
+			step.Text = text
+			trace.Steps = append(trace.Steps, step)
+			continue
+		}
+
+		cr := active[0]
+		active = active[1:]
+
+		// Matches come in increasing order on both sides, so rune offsets are counted incrementally
+		var spans [][2][2]int
+		output := cr.apply(text, func(in, out [2]int) {
+			spans = append(spans, [2][2]int{in, out})
+		})
+		inRunes := &runeCounter{text: text}
+		outRunes := &runeCounter{text: output}
+		for _, sp := range spans {
+			in, out := sp[0], sp[1]
+			step.Matches = append(step.Matches, TraceMatch{
+				Input:  Span{Start: in[0], End: in[1], RuneStart: inRunes.at(in[0]), RuneEnd: inRunes.at(in[1])},
+				Output: Span{Start: out[0], End: out[1], RuneStart: outRunes.at(out[0]), RuneEnd: outRunes.at(out[1])},
+			})
+		}
+
+		step.Count = len(step.Matches)
+		step.Text = output
+		trace.Steps = append(trace.Steps, step)
+		text = output
+	}
+
+	trace.Output = text
+	return trace, nil
+}
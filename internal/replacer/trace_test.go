@@ -0,0 +1,106 @@
+package replacer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// spanText returns the text of a span, checking that its rune offsets agree with its byte offsets
+func spanText(t *testing.T, text string, sp Span) string {
+	t.Helper()
+	if runes := []rune(text[:sp.Start]); len(runes) != sp.RuneStart {
+		t.Errorf("span %+v: rune start should be %d", sp, len(runes))
+	}
+	if runes := []rune(text[:sp.End]); len(runes) != sp.RuneEnd {
+		t.Errorf("span %+v: rune end should be %d", sp, len(runes))
+	}
+	return text[sp.Start:sp.End]
+}
+
+func TestReplaceTrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		input string
+		// Each step as "count: input match->output match, ..." followed by the text after it
+		want []string
+	}{
+		{"plain replacements", []Rule{
+			{Name: "number", Pattern: `\d+`, Replacement: "<N>", Active: true},
+		}, "a 12 b 345", []string{"2: 12-><N>, 345-><N> | a <N> b <N>"}},
+		{"multi-byte text", []Rule{
+			{Name: "word", Pattern: `名前=(\S+)`, Replacement: "名前=<$1>", Active: true},
+		}, "ユーザー 名前=太郎 ok", []string{"1: 名前=太郎->名前=<太郎> | ユーザー 名前=<太郎> ok"}},
+		{"steps chain and skip inactive rules", []Rule{
+			{Name: "ip", Pattern: `\d+\.\d+\.\d+\.\d+`, Replacement: "<IP>", Active: true},
+			{Name: "off", Pattern: `IP`, Replacement: "x", Active: false},
+			{Name: "tag", Pattern: `<(\w+)>`, Replacement: "[$1]", Active: true},
+		}, "é from 10.0.0.1", []string{
+			"1: 10.0.0.1-><IP> | é from <IP>",
+			"skipped | é from <IP>",
+			"1: <IP>->[IP] | é from [IP]",
+		}},
+		{"no match", []Rule{
+			{Name: "number", Pattern: `\d+`, Replacement: "<N>", Active: true},
+		}, "none", []string{"0:  | none"}},
+		{"limit and check", []Rule{
+			{Name: "card", Pattern: `\d{16}`, Replacement: "<CARD>", Check: "luhn", Limit: 1, Active: true},
+		}, "1234567812345678 4111111111111111 5500000000000004", []string{"1: 4111111111111111-><CARD> | 1234567812345678 <CARD> 5500000000000004"}},
+		{"line scope offsets", []Rule{
+			{Name: "first", Pattern: `\S+`, Replacement: "W", Scope: ScopeLine, Limit: 1, Active: true},
+		}, "ab cd\r\nü ef", []string{"2: ab->W, ü->W | W cd\r\nW ef"}},
+		{"template and pseudonym", []Rule{
+			{Name: "user", Pattern: `user=(?P<value>\w+)`, Pseudonym: "user", Active: true},
+			{Name: "n", Pattern: `#(\w+)`, Replacement: `#${1|upper}`, Active: true},
+		}, "user=bob user=ann user=bob #abc", []string{
+			"3: user=bob->user=user_1, user=ann->user=user_2, user=bob->user=user_1 | user=user_1 user=user_2 user=user_1 #abc",
+			"1: #abc->#ABC | user=user_1 user=user_2 user=user_1 #ABC",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace, err := NewReplacer(t.TempDir()).ReplaceTrace(tt.input, tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trace.Input != tt.input || len(trace.Steps) != len(tt.rules) {
+				t.Fatalf("got input %q and %d steps", trace.Input, len(trace.Steps))
+			}
+
+			text := tt.input
+			for i, step := range trace.Steps {
+				if step.Index != i || step.Name != tt.rules[i].Name {
+					t.Errorf("step %d: got index %d name %q", i, step.Index, step.Name)
+				}
+				var got string
+				if step.Skipped {
+					got = "skipped"
+				} else {
+					var matches []string
+					for _, m := range step.Matches {
+						matches = append(matches, spanText(t, text, m.Input)+"->"+spanText(t, step.Text, m.Output))
+					}
+					got = fmt.Sprintf("%d: %s", step.Count, strings.Join(matches, ", "))
+				}
+				got += " | " + step.Text
+				if i < len(tt.want) && got != tt.want[i] {
+					t.Errorf("step %d:\ngot  %q\nwant %q", i, got, tt.want[i])
+				}
+				text = step.Text
+			}
+
+			want, err := NewReplacer(t.TempDir()).Replace(tt.input, tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if trace.Output != want || text != want {
+				t.Errorf("trace ends with %q, Replace gives %q", trace.Output, want)
+			}
+		})
+	}
+
+	if _, err := NewReplacer(t.TempDir()).ReplaceTrace("x", []Rule{{Name: "bad", Pattern: "(", Active: true}}); err == nil {
+		t.Error("an invalid rule was traced")
+	}
+}
@@ -0,0 +1,172 @@
+package scanner
+
+import (
+	"context"
+	"sync/atomic"
+
+	"logana/internal/replacer"
+)
+
+// Supported values for SearchOptions.Dedupe
+const (
+	DedupeExact  = "exact"  // Collapse identical lines
+	DedupeMasked = "masked" // Collapse lines identical after masking numbers, IDs and timestamps
+)
+
+// masker normalizes lines before they are compared in masked dedupe mode
+type masker struct {
+	rules *replacer.Compiled
+}
+
+func newMasker() (*masker, error) {
+	rules, err := replacer.Compile(replacer.DedupeRules())
+	if err != nil {
+		return nil, err
+	}
+	return &masker{rules: rules}, nil
+}
+
+func (mk *masker) mask(line string) string {
+	return mk.rules.Replace(line)
+}
+
+// lineGroup collects the occurrences of one distinct line
+type lineGroup struct {
+	first      Match // First occurrence; LineNumber is chunk-relative until merged
+	count      int64
+	lastOffset int64
+	lastLine   int64
+}
+
+// chunkGroups holds the distinct lines of one chunk in order of first appearance
+type chunkGroups struct {
+	groups map[string]*lineGroup
+	order  []string
+	extra  map[string]*lineGroup // Distinct lines past maxResults, only counted
+	lines  int64
+}
+
+// dedupeChunk groups the matching lines of a chunk instead of streaming them
+func (fs *fileScan) dedupeChunk(ctx context.Context, chunkIdx, start, end int64) error {
+	cg := &chunkGroups{groups: make(map[string]*lineGroup), extra: make(map[string]*lineGroup)}
+
+	err := fs.eachLine(ctx, start, end, func(lineBytes []byte, parsed map[string]string, rest []bool, offset int64) bool {
+		cg.lines++
+		line, fields, ok := fs.accept(lineBytes, parsed, rest)
+		if !ok {
+			return true
+		}
+
+		key := line
+		if fs.mask != nil {
+			key = fs.mask.mask(line)
+		}
+		if g, ok := cg.groups[key]; ok {
+			g.count++
+			g.lastOffset = offset
+			g.lastLine = cg.lines
+			return true
+		}
+		if int64(len(cg.order)) >= fs.maxResults {
+			// Too many distinct lines to keep them all, but an earlier chunk
+			// may have kept this one, so its occurrences are still counted
+			g := cg.extra[key]
+			if g == nil {
+				g = &lineGroup{}
+				cg.extra[key] = g
+			}
+			g.count++
+			g.lastOffset = offset
+			g.lastLine = cg.lines
+			return true
+		}
+
+		content, cut := truncateLine(line)
+		cg.groups[key] = &lineGroup{
+			first: Match{
+				File:       fs.path,
+				LineNumber: int(cg.lines),
+				Content:    content,
+				Offset:     offset,
+				Fields:     fields,
+				Truncated:  cut,
+			},
+			count:      1,
+			lastOffset: offset,
+			lastLine:   cg.lines,
+		}
+		cg.order = append(cg.order, key)
+		return true
+	})
+
+	fs.mergeGroups(chunkIdx, cg)
+	return err
+}
+
+// mergeGroups adds the groups of each chunk to those of the file once all
+// earlier chunks are merged, making line numbers absolute. Only the first
+// maxResults distinct lines are kept. Lines counted past the cap of a chunk
+// only add to lines kept from earlier chunks; the others cannot be among the
+// results and are dropped.
+func (fs *fileScan) mergeGroups(chunkIdx int64, cg *chunkGroups) {
+	fs.orderMu.Lock()
+	defer fs.orderMu.Unlock()
+
+	fs.chunkGroups[chunkIdx] = cg
+	for {
+		cg, ok := fs.chunkGroups[fs.next]
+		if !ok {
+			return
+		}
+		delete(fs.chunkGroups, fs.next)
+		fs.next++
+
+		for _, key := range cg.order {
+			g := cg.groups[key]
+			if mg, ok := fs.groups[key]; ok {
+				mg.add(g, fs.lineBase)
+				continue
+			}
+			if int64(len(fs.groupOrder)) >= fs.maxResults {
+				continue // Never sent, see emitGroups
+			}
+			g.first.LineNumber += int(fs.lineBase)
+			g.lastLine += fs.lineBase
+			fs.groups[key] = g
+			fs.groupOrder = append(fs.groupOrder, key)
+		}
+		for key, g := range cg.extra {
+			if mg, ok := fs.groups[key]; ok {
+				mg.add(g, fs.lineBase)
+			}
+		}
+		fs.lineBase += cg.lines
+	}
+}
+
+// add counts the occurrences of the same line in a later chunk
+func (g *lineGroup) add(later *lineGroup, lineBase int64) {
+	g.count += later.count
+	g.lastOffset = later.lastOffset
+	g.lastLine = later.lastLine + lineBase
+}
+
+// emitGroups sends one Match per distinct line of the merged chunks
+func (fs *fileScan) emitGroups() {
+	for _, key := range fs.groupOrder {
+		if atomic.LoadInt64(fs.counter) >= fs.maxResults {
+			return
+		}
+		g := fs.groups[key]
+		m := g.first
+		m.Count = g.count
+		m.LastOffset = g.lastOffset
+		m.LastLine = int(g.lastLine)
+		m.Spans = fs.m.spans(m.Content, 0, 0)
+		if fs.hl != nil {
+			m.Colors = fs.hl.Spans(m.Content)
+		}
+		fs.results <- m
+		atomic.AddInt64(fs.counter, 1)
+	}
+}
@@ -0,0 +1,60 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// groupCounts formats dedupe results as "content=count@first-last"
+func groupCounts(matches []Match) string {
+	var out []string
+	for _, m := range matches {
+		out = append(out, fmt.Sprintf("%s=%d@%d-%d", m.Content, m.Count, m.LineNumber, m.LastLine))
+	}
+	return strings.Join(out, " ")
+}
+
+func TestScanDedupe(t *testing.T) {
+	lines := []string{
+		"took 15ms", "retry", "took 30ms", "retry",
+		"took 15ms", "done", "retry", "took 15ms",
+	}
+	path := writeLog(t, "app.log", lines)
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{DedupeExact, "took 15ms=3@1-8 retry=3@2-7 took 30ms=1@3-3 done=1@6-6"},
+		{DedupeMasked, "took 15ms=4@1-8 retry=3@2-7 done=1@6-6"},
+	}
+	for _, tt := range tests {
+		for _, chunkSize := range []int64{4, 1 << 20} {
+			t.Run(fmt.Sprint(tt.mode, "/", chunkSize), func(t *testing.T) {
+				matches, _ := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "o r", Logic: "OR", Dedupe: tt.mode})
+				if got := groupCounts(matches); got != tt.want {
+					t.Errorf("got %s, want %s", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestScanDedupeCap(t *testing.T) {
+	// Four 6-byte lines per 24-byte chunk; every chunk reaches the cap of two distinct lines
+	path := writeLog(t, "app.log", []string{
+		"key A", "key B", "key A", "key B",
+		"key C", "key D", "key A", "key A",
+		"key A", "key E", "key F", "key A",
+	})
+
+	for _, chunkSize := range []int64{24, 1 << 20} {
+		t.Run(fmt.Sprint(chunkSize), func(t *testing.T) {
+			matches, _ := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "key", Dedupe: DedupeExact, MaxResults: 2})
+			if got, want := groupCounts(matches), "key A=6@1-12 key B=2@2-4"; got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
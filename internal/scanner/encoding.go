@@ -0,0 +1,227 @@
+package scanner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"logana/internal/formats"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Supported values for SearchOptions.Encoding
+const (
+	EncodingAuto    = "auto"
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	EncodingGBK     = "gbk"
+	EncodingLatin1  = "latin1"
+)
+
+// sniffSize is how much of a file is inspected to detect its encoding
+const sniffSize = 8 * 1024
+
+// textEncoding describes how raw file bytes are split into lines and decoded
+type textEncoding struct {
+	name    string
+	bomLen  int    // Byte order mark length at the start of the file
+	unit    int    // Code unit width in bytes; lines always start on a unit boundary
+	newline []byte // Encoded '\n'
+	cr      []byte // Encoded '\r'
+	decoder func() *encoding.Decoder
+}
+
+// newTextEncoding returns the line/decoding description for a named encoding
+func newTextEncoding(name string, bomLen int) (*textEncoding, error) {
+	switch strings.ToLower(name) {
+	case EncodingUTF8, "utf8":
+		return &textEncoding{name: EncodingUTF8, bomLen: bomLen, unit: 1, newline: []byte{'\n'}, cr: []byte{'\r'}}, nil
+	case EncodingUTF16LE:
+		return &textEncoding{name: EncodingUTF16LE, bomLen: bomLen, unit: 2, newline: []byte{'\n', 0}, cr: []byte{'\r', 0},
+			decoder: unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder}, nil
+	case EncodingUTF16BE:
+		return &textEncoding{name: EncodingUTF16BE, bomLen: bomLen, unit: 2, newline: []byte{0, '\n'}, cr: []byte{0, '\r'},
+			decoder: unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder}, nil
+	case EncodingGBK, "gb18030", "gb2312":
+		// GBK trail bytes never fall in the ASCII control range, so '\n' splits lines safely
+		return &textEncoding{name: EncodingGBK, bomLen: bomLen, unit: 1, newline: []byte{'\n'}, cr: []byte{'\r'},
+			decoder: simplifiedchinese.GB18030.NewDecoder}, nil
+	case EncodingLatin1, "iso-8859-1":
+		return &textEncoding{name: EncodingLatin1, bomLen: bomLen, unit: 1, newline: []byte{'\n'}, cr: []byte{'\r'},
+			decoder: charmap.ISO8859_1.NewDecoder}, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding '%s'", name)
+}
+
+// readSample returns the first sniffSize bytes of the file
+func readSample(file *os.File) ([]byte, error) {
+	sample := make([]byte, sniffSize)
+	n, err := file.ReadAt(sample, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return sample[:n], nil
+}
+
+// resolveEncoding honors an explicit encoding or detects one from the start of the file
+func resolveEncoding(sample []byte, name string) (*textEncoding, error) {
+	detected, bomLen := detectEncoding(sample)
+	if name == "" || strings.EqualFold(name, EncodingAuto) {
+		return newTextEncoding(detected, bomLen)
+	}
+
+	// An explicit encoding still skips its own byte order mark
+	enc, err := newTextEncoding(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	if enc.name == detected {
+		enc.bomLen = bomLen
+	}
+	return enc, nil
+}
+
+// resolveFormat honors an explicit log format or detects one from the start
+// of the file. It returns nil when no format was requested or none was recognized.
+func resolveFormat(sample []byte, enc *textEncoding, name string) (*formats.Format, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case formats.FormatAuto:
+		text := enc.newLineDecoder().decode(sample[min(enc.bomLen, len(sample)):])
+		return formats.Detect(text), nil
+	}
+	return formats.Get(name)
+}
+
+// looksBinary reports whether the sample contains NUL bytes, like grep's heuristic.
+// UTF-16 text is full of zero bytes, so it is never treated as binary.
+func looksBinary(sample []byte, enc *textEncoding) bool {
+	if enc.unit > 1 {
+		return false
+	}
+	return bytes.IndexByte(sample, 0) >= 0
+}
+
+// detectEncoding guesses the encoding of a file from its first bytes.
+// It returns the encoding name and the length of any byte order mark.
+func detectEncoding(sample []byte) (string, int) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8, 3
+	case bytes.HasPrefix(sample, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE, 2
+	case bytes.HasPrefix(sample, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE, 2
+	}
+
+	if len(sample) == 0 {
+		return EncodingUTF8, 0
+	}
+
+	// UTF-16 without BOM: mostly-ASCII text leaves every other byte zero
+	var evenZeros, oddZeros int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenZeros++
+		} else {
+			oddZeros++
+		}
+	}
+	half := len(sample) / 2
+	if half > 0 {
+		if oddZeros > half*3/10 && evenZeros*10 < oddZeros {
+			return EncodingUTF16LE, 0
+		}
+		if evenZeros > half*3/10 && oddZeros*10 < evenZeros {
+			return EncodingUTF16BE, 0
+		}
+	}
+
+	if validUTF8Prefix(sample) {
+		return EncodingUTF8, 0
+	}
+	if validGBK(sample) {
+		return EncodingGBK, 0
+	}
+	return EncodingLatin1, 0
+}
+
+// validUTF8Prefix reports whether sample is valid UTF-8, ignoring a rune cut off at the end
+func validUTF8Prefix(sample []byte) bool {
+	for i := 0; i < utf8.UTFMax && len(sample) > 0; i++ {
+		if utf8.Valid(sample) {
+			return true
+		}
+		sample = sample[:len(sample)-1]
+	}
+	return utf8.Valid(sample)
+}
+
+// validGBK reports whether every non-ASCII byte in sample forms a well-formed GBK pair
+func validGBK(sample []byte) bool {
+	pairs := 0
+	for i := 0; i < len(sample); i++ {
+		b := sample[i]
+		if b < 0x80 {
+			continue
+		}
+		if b == 0x80 || b == 0xFF {
+			return false
+		}
+		if i+1 >= len(sample) {
+			break // Pair cut off by the sample boundary
+		}
+		t := sample[i+1]
+		if t < 0x40 || t == 0x7F || t == 0xFF {
+			return false
+		}
+		pairs++
+		i++
+	}
+	return pairs > 0
+}
+
+// trimLine strips the encoded line terminator and a preceding carriage return
+func (e *textEncoding) trimLine(raw []byte) []byte {
+	raw = bytes.TrimSuffix(raw, e.newline)
+	if len(raw)%e.unit == 0 {
+		raw = bytes.TrimSuffix(raw, e.cr)
+	}
+	return raw
+}
+
+// lineDecoder converts raw lines to UTF-8. It is not safe for concurrent use.
+type lineDecoder struct {
+	dec *encoding.Decoder
+}
+
+func (e *textEncoding) newLineDecoder() *lineDecoder {
+	if e.decoder == nil {
+		return &lineDecoder{}
+	}
+	return &lineDecoder{dec: e.decoder()}
+}
+
+// decode returns the line as UTF-8; undecodable bytes become U+FFFD
+func (d *lineDecoder) decode(raw []byte) []byte {
+	if d.dec == nil {
+		return raw
+	}
+	out, err := d.dec.Bytes(raw)
+	if err != nil {
+		return []byte(strings.ToValidUTF8(string(raw), "\uFFFD"))
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	firstLine  = "2024-03-01 启动 service\r\n"
+	encodedLog = firstLine + "2024-03-01 ERROR 数据库连接失败\r\n2024-03-01 done\r\n"
+)
+
+// encode converts text to an encoding, adding bom in front
+func encode(t *testing.T, enc encoding.Encoding, bom []byte, text string) []byte {
+	t.Helper()
+	out, err := enc.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(append([]byte{}, bom...), out...)
+}
+
+var (
+	utf16LE = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	utf16BE = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		sample  []byte
+		want    string
+		wantBOM int
+	}{
+		{"empty", nil, EncodingUTF8, 0},
+		{"ascii", []byte("plain text\n"), EncodingUTF8, 0},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, "日志\n"...), EncodingUTF8, 3},
+		{"utf-8 without bom", []byte(encodedLog), EncodingUTF8, 0},
+		{"utf-8 cut inside a rune", []byte(encodedLog)[:len("2024-03-01 启")-1], EncodingUTF8, 0},
+		{"utf-16le bom", encode(t, utf16LE, []byte{0xFF, 0xFE}, encodedLog), EncodingUTF16LE, 2},
+		{"utf-16be bom", encode(t, utf16BE, []byte{0xFE, 0xFF}, encodedLog), EncodingUTF16BE, 2},
+		{"utf-16le without bom", encode(t, utf16LE, nil, "plain ascii log line\n"), EncodingUTF16LE, 0},
+		{"utf-16be without bom", encode(t, utf16BE, nil, "plain ascii log line\n"), EncodingUTF16BE, 0},
+		{"gbk", encode(t, simplifiedchinese.GBK, nil, encodedLog), EncodingGBK, 0},
+		{"latin1", []byte("caf\xe9 cr\xe8me\n"), EncodingLatin1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, bom := detectEncoding(tt.sample)
+			if got != tt.want || bom != tt.wantBOM {
+				t.Errorf("got %s with %d byte BOM, want %s with %d", got, bom, tt.want, tt.wantBOM)
+			}
+		})
+	}
+}
+
+func TestScanEncodedFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string // SearchOptions.Encoding; empty detects it
+		offset   int64  // Raw offset of the second line
+	}{
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, encodedLog...), "", 3 + int64(len(firstLine))},
+		{"utf-16le bom", encode(t, utf16LE, []byte{0xFF, 0xFE}, encodedLog), "", int64(len(encode(t, utf16LE, []byte{0xFF, 0xFE}, firstLine)))},
+		{"utf-16be bom", encode(t, utf16BE, []byte{0xFE, 0xFF}, encodedLog), "", int64(len(encode(t, utf16BE, []byte{0xFE, 0xFF}, firstLine)))},
+		{"utf-16le explicit", encode(t, utf16LE, nil, encodedLog), EncodingUTF16LE, int64(len(encode(t, utf16LE, nil, firstLine)))},
+		{"gbk", encode(t, simplifiedchinese.GBK, nil, encodedLog), "", int64(len(encode(t, simplifiedchinese.GBK, nil, firstLine)))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Chunks of 5 bytes also split UTF-16 code units and GBK pairs
+			for _, chunkSize := range []int64{5, 1 << 20} {
+				matches, summary := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "数据库", Encoding: tt.encoding, Ordered: true})
+				if len(matches) != 1 {
+					t.Fatalf("chunk size %d: got %d matches, want 1", chunkSize, len(matches))
+				}
+				m := matches[0]
+				if want := "2024-03-01 ERROR 数据库连接失败"; m.Content != want || m.LineNumber != 2 || m.Offset != tt.offset {
+					t.Errorf("chunk size %d: got line %d offset %d %q, want line 2 offset %d %q",
+						chunkSize, m.LineNumber, m.Offset, m.Content, tt.offset, want)
+				}
+				if len(m.Spans) != 1 || m.Content[m.Spans[0].Start:m.Spans[0].End] != "数据库" {
+					t.Errorf("chunk size %d: got spans %+v", chunkSize, m.Spans)
+				}
+				if enc := summary.Encodings[path]; tt.encoding == "" && !strings.HasPrefix(tt.name, enc) {
+					t.Errorf("detected %s", enc)
+				}
+			}
+		})
+	}
+}
+
+func TestScanUnsupportedEncoding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := NewParallelScanner(1).Scan(context.Background(), SearchOptions{FilePath: path, Query: "x", Encoding: "ebcdic"}, nil, make(chan Match, 1))
+	if err == nil || !strings.Contains(err.Error(), "unsupported encoding") {
+		t.Errorf("got %v, want an unsupported encoding error", err)
+	}
+}
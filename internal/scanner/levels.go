@@ -0,0 +1,188 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Normalized log levels, from least to most severe
+const (
+	LevelTrace   = "trace"
+	LevelDebug   = "debug"
+	LevelInfo    = "info"
+	LevelWarn    = "warn"
+	LevelError   = "error"
+	LevelFatal   = "fatal"
+	LevelUnknown = "unknown" // No level token was recognized
+)
+
+var levelOrder = []string{LevelUnknown, LevelTrace, LevelDebug, LevelInfo, LevelWarn, LevelError, LevelFatal}
+
+// levelAliases maps the upper-cased level tokens found in logs to an index in levelOrder
+var levelAliases = map[string]int{
+	"TRACE": 1, "TRC": 1, "FINEST": 1,
+	"DEBUG": 2, "DBG": 2, "FINE": 2,
+	"INFO": 3, "INF": 3, "NOTICE": 3, "INFORMATION": 3,
+	"WARN": 4, "WARNING": 4, "WRN": 4,
+	"ERROR": 5, "ERR": 5, "SEVERE": 5,
+	"FATAL": 6, "FTL": 6, "CRIT": 6, "CRITICAL": 6, "PANIC": 6, "EMERG": 6, "ALERT": 6,
+}
+
+// levelKeys are the key=value and JSON keys that carry a level
+var levelKeys = []string{"level", "lvl", "severity", "loglevel"}
+
+// levelHead bounds how far into a line bare and bracketed level tokens are looked for
+const levelHead = 128
+
+// detectLevel returns the index in levelOrder of the level of a line. It
+// recognizes level=ERROR and "level":"error" anywhere in the line, and
+// [error], <Error> or a bare upper-case ERROR token near its start.
+func detectLevel(line string) int {
+	for _, key := range levelKeys {
+		if level := keyedLevel(line, key); level > 0 {
+			return level
+		}
+	}
+
+	head := line
+	if len(head) > levelHead {
+		head = head[:levelHead]
+	}
+	for i := 0; i < len(head); {
+		if !isLetter(head[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(head) && isLetter(head[j]) {
+			j++
+		}
+		word := head[i:j]
+		if len(word) >= 3 && len(word) <= 11 {
+			bracketed := i > 0 && strings.IndexByte("[(<", head[i-1]) >= 0 &&
+				j < len(head) && strings.IndexByte("])>", head[j]) >= 0
+			if bracketed || strings.ToUpper(word) == word {
+				if level, ok := levelAliases[strings.ToUpper(word)]; ok {
+					return level
+				}
+			}
+		}
+		i = j
+	}
+	return 0
+}
+
+// keyedLevel looks for key=value or "key": "value" and returns the level of the value
+func keyedLevel(line, key string) int {
+	for from := 0; ; {
+		i := strings.Index(line[from:], key)
+		if i < 0 {
+			return 0
+		}
+		i += from
+		from = i + len(key)
+		if i > 0 && isLetter(line[i-1]) {
+			continue // Part of a longer key
+		}
+
+		rest := strings.TrimLeft(line[from:], `"' `)
+		if rest == "" || (rest[0] != '=' && rest[0] != ':') {
+			continue
+		}
+		rest = strings.TrimLeft(rest[1:], `"' `)
+		j := 0
+		for j < len(rest) && isLetter(rest[j]) {
+			j++
+		}
+		if level, ok := levelAliases[strings.ToUpper(rest[:j])]; ok {
+			return level
+		}
+	}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// levelFilter tracks the levels of matching lines and applies MinLevel and Levels
+type levelFilter struct {
+	allowed []bool // Indexed like levelOrder; nil when no filter is set
+	counts  []int64
+}
+
+func newLevelFilter(opts SearchOptions) (*levelFilter, error) {
+	lf := &levelFilter{counts: make([]int64, len(levelOrder))}
+	if opts.MinLevel == "" && len(opts.Levels) == 0 {
+		return lf, nil
+	}
+
+	lf.allowed = make([]bool, len(levelOrder))
+	for i := range lf.allowed {
+		lf.allowed[i] = true
+	}
+	if opts.MinLevel != "" {
+		min, err := levelIndex(opts.MinLevel)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < min; i++ {
+			lf.allowed[i] = false
+		}
+	}
+	if len(opts.Levels) > 0 {
+		only := make([]bool, len(levelOrder))
+		for _, name := range opts.Levels {
+			i, err := levelIndex(name)
+			if err != nil {
+				return nil, err
+			}
+			only[i] = true
+		}
+		for i := range lf.allowed {
+			lf.allowed[i] = lf.allowed[i] && only[i]
+		}
+	}
+	return lf, nil
+}
+
+// levelIndex resolves a level name or alias to its index in levelOrder
+func levelIndex(name string) (int, error) {
+	if strings.EqualFold(name, LevelUnknown) {
+		return 0, nil
+	}
+	if i, ok := levelAliases[strings.ToUpper(name)]; ok {
+		return i, nil
+	}
+	return 0, fmt.Errorf("unknown log level '%s'", name)
+}
+
+// active reports whether lines are filtered by level
+func (lf *levelFilter) active() bool {
+	return lf.allowed != nil
+}
+
+// accept counts a matching line under its level and reports whether the filter keeps it.
+// parsed is the level found by the log format parser, if any.
+func (lf *levelFilter) accept(line, parsed string) bool {
+	level := 0
+	if parsed != "" {
+		level = levelAliases[strings.ToUpper(parsed)]
+	}
+	if level == 0 {
+		level = detectLevel(line)
+	}
+	atomic.AddInt64(&lf.counts[level], 1)
+	return lf.allowed == nil || lf.allowed[level]
+}
+
+// summary returns the non-zero counts by level name
+func (lf *levelFilter) summary() map[string]int64 {
+	counts := make(map[string]int64)
+	for i, name := range levelOrder {
+		if n := atomic.LoadInt64(&lf.counts[i]); n > 0 {
+			counts[name] = n
+		}
+	}
+	return counts
+}
@@ -0,0 +1,124 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDetectLevel(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"2024-03-01 10:00:00 ERROR db connection lost", LevelError},
+		{"2024-03-01 10:00:00 [warn] disk almost full", LevelWarn},
+		{"<Debug> cache miss", LevelDebug},
+		{"(info) started", LevelInfo},
+		{"ts=1 level=fatal msg=\"out of memory\"", LevelFatal},
+		{"ts=1 lvl=WRN msg=retry", LevelWarn},
+		{`{"time": "10:00", "level": "error", "msg": "failed"}`, LevelError},
+		{`{"severity":"NOTICE"}`, LevelInfo},
+		{"SEVERE: servlet threw an exception", LevelError},
+		{"10:00:00 TRC cache warmed", LevelTrace},
+		{"user error: an error in lower case is not a level", LevelUnknown},
+		{"loglevel=debug overrides the INFO token", LevelDebug},
+		{"sublevel=error is part of a longer key", LevelUnknown},
+		{"Information about the build", LevelUnknown},
+		{"no level here", LevelUnknown},
+		{strings.Repeat("x", levelHead) + " ERROR too far into the line", LevelUnknown},
+		{strings.Repeat("x", levelHead) + " level=error keyed levels are found anywhere", LevelError},
+	}
+	for _, tt := range tests {
+		if got := levelOrder[detectLevel(tt.line)]; got != tt.want {
+			t.Errorf("%.60q: got %s, want %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	lines := []string{"DEBUG a", "INFO b", "WARN c", "ERROR d", "FATAL e", "plain f"}
+
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want string
+	}{
+		{"no filter", SearchOptions{}, "abcdef"},
+		{"min level", SearchOptions{MinLevel: "warn"}, "cde"},
+		{"min level alias", SearchOptions{MinLevel: "ERR"}, "de"},
+		{"levels", SearchOptions{Levels: []string{"info", "unknown"}}, "bf"},
+		{"both", SearchOptions{MinLevel: "warn", Levels: []string{"info", "fatal"}}, "e"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lf, err := newLevelFilter(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			for _, line := range lines {
+				if lf.accept(line, "") {
+					got += line[len(line)-1:]
+				}
+			}
+			if got != tt.want {
+				t.Errorf("kept %s, want %s", got, tt.want)
+			}
+			want := map[string]int64{"debug": 1, "info": 1, "warn": 1, "error": 1, "fatal": 1, "unknown": 1}
+			if fmt.Sprint(lf.summary()) != fmt.Sprint(want) {
+				t.Errorf("counted %v, want every line under its level", lf.summary())
+			}
+		})
+	}
+
+	for _, opts := range []SearchOptions{{MinLevel: "loud"}, {Levels: []string{"warn", "verbose"}}} {
+		if _, err := newLevelFilter(opts); err == nil || !strings.Contains(err.Error(), "unknown log level") {
+			t.Errorf("%+v: got %v, want an unknown level error", opts, err)
+		}
+	}
+}
+
+func TestLevelFilterPrefersParsedLevel(t *testing.T) {
+	lf, _ := newLevelFilter(SearchOptions{MinLevel: "error"})
+	if !lf.accept("INFO text that mentions a level", "err") {
+		t.Error("the parsed level was ignored")
+	}
+	if lf.accept("ERROR in the text", "notice") {
+		t.Error("the detected level overrode the parsed one")
+	}
+	if !lf.accept("ERROR in the text", "not-a-level") {
+		t.Error("an unknown parsed level must fall back to detection")
+	}
+}
+
+func TestScanLevels(t *testing.T) {
+	path := writeLog(t, "app.log", []string{
+		"10:00 INFO request 1", "10:01 WARN request 2 slow", "10:02 ERROR request 3 failed",
+		"10:03 request 4", "10:04 ERROR request 5 failed",
+	})
+
+	for _, chunkSize := range []int64{8, 1 << 20} {
+		t.Run(fmt.Sprint(chunkSize), func(t *testing.T) {
+			matches, summary := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "request", MinLevel: "warn", Ordered: true})
+			var got []int
+			for _, m := range matches {
+				got = append(got, m.LineNumber)
+			}
+			if fmt.Sprint(got) != "[2 3 5]" {
+				t.Errorf("got lines %v, want [2 3 5]", got)
+			}
+			// Counts cover every line matching the query, before the level filter
+			want := map[string]int64{"info": 1, "warn": 1, "error": 2, "unknown": 1}
+			if fmt.Sprint(summary.Levels) != fmt.Sprint(want) {
+				t.Errorf("got level counts %v, want %v", summary.Levels, want)
+			}
+		})
+	}
+
+	// A level filter without a query still selects lines
+	matches, _ := scanAll(t, 1<<20, SearchOptions{FilePath: path, Levels: []string{"unknown"}})
+	if len(matches) != 1 || matches[0].Content != "10:03 request 4" {
+		t.Errorf("level filter without query: got %+v", matches)
+	}
+}
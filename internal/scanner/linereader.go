@@ -0,0 +1,177 @@
+package scanner
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+const (
+	// maxLineSize is how much of a single line is kept; the rest is only passed to lineReader.rest
+	maxLineSize = 10 * 1024 * 1024
+	// restOverlap is how much of the data before each piece of a skipped
+	// remainder is passed again, so a term across two pieces is still found
+	restOverlap = 64 * 1024
+	// maxDisplayLine is how much of a single line is returned in Match.Content
+	maxDisplayLine = 64 * 1024
+)
+
+// lineReader yields raw lines from a reader without the line length limit of
+// bufio.Scanner. Lines longer than maxLineSize are cut; the remainder is skipped
+// but still counted so offsets stay exact.
+type lineReader struct {
+	r          io.Reader
+	enc        *textEncoding
+	buf        []byte
+	start, end int // Unconsumed data is buf[start:end]
+	eof        bool
+	err        error
+
+	// rest, if set, receives the skipped remainder of a cut line in pieces
+	// that overlap by restOverlap bytes, the first one overlapping the kept part
+	rest func(piece []byte)
+}
+
+func newLineReader(r io.Reader, enc *textEncoding) *lineReader {
+	return &lineReader{
+		r:   r,
+		enc: enc,
+		buf: make([]byte, 1024*1024),
+	}
+}
+
+// next returns the next raw line including its terminator, the number of bytes
+// the line occupies in the file, and whether the returned line was cut short.
+// ok is false at the end of input or after a read error (see Err).
+func (lr *lineReader) next() (line []byte, size int64, truncated bool, ok bool) {
+	for {
+		if i := lr.enc.indexNewline(lr.buf[lr.start:lr.end]); i >= 0 {
+			n := i + len(lr.enc.newline)
+			line = lr.buf[lr.start : lr.start+n]
+			lr.start += n
+			return line, int64(n), false, true
+		}
+
+		if lr.eof || lr.err != nil {
+			if lr.start == lr.end {
+				return nil, 0, false, false
+			}
+			line = lr.buf[lr.start:lr.end]
+			lr.start = lr.end
+			return line, int64(len(line)), false, true
+		}
+
+		if lr.end-lr.start >= maxLineSize {
+			return lr.skipRest()
+		}
+
+		lr.fill()
+	}
+}
+
+// skipRest keeps the first maxLineSize bytes of the current line and discards
+// everything up to and including its terminator, passing it to rest.
+func (lr *lineReader) skipRest() ([]byte, int64, bool, bool) {
+	keep := maxLineSize - maxLineSize%lr.enc.unit
+	line := append([]byte(nil), lr.buf[lr.start:lr.start+keep]...)
+	size := int64(keep)
+
+	// The first overlap bytes at lr.start have been counted and searched for a terminator
+	overlap := min(restOverlap, keep)
+	lr.start += keep - overlap
+	for {
+		data := lr.buf[lr.start:lr.end]
+		if i := lr.enc.indexNewline(data[overlap:]); i >= 0 {
+			n := overlap + i
+			lr.scanRest(data[:n])
+			lr.start += n + len(lr.enc.newline)
+			return line, size + int64(n-overlap+len(lr.enc.newline)), true, true
+		}
+
+		if lr.eof || lr.err != nil {
+			lr.scanRest(data)
+			size += int64(len(data) - overlap)
+			lr.start = lr.end
+			return line, size, true, true
+		}
+
+		// Keep a partial code unit so the terminator search stays aligned
+		skip := len(data) - len(data)%lr.enc.unit
+		lr.scanRest(data[:skip])
+		size += int64(skip - overlap)
+		overlap = min(restOverlap, skip)
+		lr.start += skip - overlap
+		lr.fill()
+	}
+}
+
+func (lr *lineReader) scanRest(piece []byte) {
+	if lr.rest != nil {
+		lr.rest(piece)
+	}
+}
+
+// fill compacts the buffer, grows it up to maxLineSize if full, and reads more data
+func (lr *lineReader) fill() {
+	if lr.start > 0 {
+		copy(lr.buf, lr.buf[lr.start:lr.end])
+		lr.end -= lr.start
+		lr.start = 0
+	}
+
+	if lr.end == len(lr.buf) {
+		size := len(lr.buf) * 2
+		if size > maxLineSize {
+			size = maxLineSize
+		}
+		if size > len(lr.buf) {
+			buf := make([]byte, size)
+			copy(buf, lr.buf[:lr.end])
+			lr.buf = buf
+		}
+	}
+
+	n, err := lr.r.Read(lr.buf[lr.end:])
+	lr.end += n
+	if err == io.EOF {
+		lr.eof = true
+	} else if err != nil {
+		lr.err = err
+	}
+}
+
+// Err returns the first non-EOF read error
+func (lr *lineReader) Err() error {
+	return lr.err
+}
+
+// indexNewline returns the position of the first aligned line terminator in data, or -1
+func (e *textEncoding) indexNewline(data []byte) int {
+	if e.unit == 1 {
+		return bytes.IndexByte(data, '\n')
+	}
+	for from := 0; from < len(data); {
+		i := bytes.Index(data[from:], e.newline)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		if i%e.unit == 0 {
+			return i
+		}
+		from = i + 1
+	}
+	return -1
+}
+
+// truncateLine cuts a decoded line to maxDisplayLine bytes on a rune boundary
+func truncateLine(line string) (string, bool) {
+	if len(line) <= maxDisplayLine {
+		return line, false
+	}
+	cut := maxDisplayLine
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	return line[:cut], true
+}
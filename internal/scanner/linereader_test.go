@@ -0,0 +1,111 @@
+package scanner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readLines reads all lines of data and rebuilds the skipped remainder of
+// each cut line from the overlapping pieces passed to rest
+func readLines(t *testing.T, data []byte, encName string) (lines []string, sizes []int64, cut []bool, rests []string) {
+	t.Helper()
+	enc, err := newTextEncoding(encName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr := newLineReader(iotest.HalfReader(bytes.NewReader(data)), enc)
+	var rest []byte
+	var prev int
+	lr.rest = func(piece []byte) {
+		rest = append(rest, piece[min(prev, restOverlap):]...)
+		prev = len(piece)
+	}
+	for {
+		line, size, truncated, ok := lr.next()
+		if !ok {
+			break
+		}
+		lines = append(lines, string(line))
+		sizes = append(sizes, size)
+		cut = append(cut, truncated)
+		rests = append(rests, string(rest))
+		rest, prev = nil, 0
+	}
+	if err := lr.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines, sizes, cut, rests
+}
+
+func TestLineReaderLongLines(t *testing.T) {
+	long := strings.Repeat("a", maxLineSize-2) + "BOUNDARY" + strings.Repeat("b", 3*restOverlap) + "END"
+	lines, sizes, cut, rests := readLines(t, []byte("first\n"+long+"\r\nshort\nlast"), EncodingUTF8)
+
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4", len(lines))
+	}
+	if lines[0] != "first\n" || lines[2] != "short\n" || lines[3] != "last" || cut[0] || cut[2] || cut[3] {
+		t.Errorf("short lines: got %q, cut %v", []string{lines[0], lines[2], lines[3]}, cut)
+	}
+	if !cut[1] || lines[1] != long[:maxLineSize] {
+		t.Errorf("long line: cut %v, kept %d bytes, want %d", cut[1], len(lines[1]), maxLineSize)
+	}
+	if want := int64(len(long) + 2); sizes[1] != want {
+		t.Errorf("long line occupies %d bytes, want %d", sizes[1], want)
+	}
+	// The remainder starts restOverlap bytes before the cut and runs to the terminator
+	if want := long[maxLineSize-restOverlap:] + "\r"; rests[1] != want {
+		t.Errorf("rest has %d bytes ending in %q, want %d ending in %q", len(rests[1]), rests[1][max(0, len(rests[1])-4):], len(want), want[len(want)-4:])
+	}
+	if !strings.Contains(rests[1], "BOUNDARY") {
+		t.Error("a term across the cut is not in the rest")
+	}
+}
+
+func TestLineReaderLongLineAtEOF(t *testing.T) {
+	long := strings.Repeat("x", maxLineSize+10)
+	lines, sizes, cut, rests := readLines(t, []byte(long), EncodingUTF8)
+	if len(lines) != 1 || !cut[0] || sizes[0] != int64(len(long)) {
+		t.Fatalf("got %d lines, cut %v, sizes %v", len(lines), cut, sizes)
+	}
+	if rests[0] != long[maxLineSize-restOverlap:] {
+		t.Errorf("rest has %d bytes, want %d", len(rests[0]), len(long)-maxLineSize+restOverlap)
+	}
+}
+
+func TestLineReaderUTF16(t *testing.T) {
+	// 0x0A00 holds a newline byte that is not a UTF-16LE line terminator
+	data := []byte{'a', 0, 0x00, 0x0A, '\n', 0, 'b', 0, '\n', 0}
+	lines, sizes, cut, _ := readLines(t, data, EncodingUTF16LE)
+	if len(lines) != 2 || lines[0] != string(data[:6]) || lines[1] != string(data[6:]) || cut[0] || cut[1] {
+		t.Fatalf("got %q", lines)
+	}
+	if sizes[0] != 6 || sizes[1] != 4 {
+		t.Errorf("got sizes %v", sizes)
+	}
+
+	long := bytes.Repeat([]byte{'z', 0}, maxLineSize/2+restOverlap)
+	lines, sizes, cut, rests := readLines(t, append(long, '\n', 0, 'e', 0), EncodingUTF16LE)
+	if len(lines) != 2 || !cut[0] || len(lines[0]) != maxLineSize || sizes[0] != int64(len(long)+2) || lines[1] != "e\x00" {
+		t.Fatalf("got %d lines, cut %v, sizes %v", len(lines), cut, sizes)
+	}
+	if len(rests[0])%2 != 0 || len(rests[0]) != len(long)-maxLineSize+restOverlap {
+		t.Errorf("rest has %d bytes", len(rests[0]))
+	}
+}
+
+func TestTruncateLine(t *testing.T) {
+	short := "short line"
+	if got, cut := truncateLine(short); got != short || cut {
+		t.Errorf("short line: got %q, %v", got, cut)
+	}
+
+	// A rune across the limit is left out whole
+	long := strings.Repeat("a", maxDisplayLine-1) + "é" + "tail"
+	got, cut := truncateLine(long)
+	if !cut || got != long[:maxDisplayLine-1] {
+		t.Errorf("long line: cut %v, got %d bytes", cut, len(got))
+	}
+}
@@ -0,0 +1,261 @@
+package scanner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"logana/internal/formats"
+)
+
+// Span locates one match of a query term inside Match.Content.
+// Start/End are byte offsets, RuneStart/RuneEnd are code point offsets.
+type Span struct {
+	Term      int `json:"term"`
+	Start     int `json:"start"`
+	End       int `json:"end"`
+	RuneStart int `json:"runeStart"`
+	RuneEnd   int `json:"runeEnd"`
+}
+
+// matcher decides whether a line satisfies the query terms of a search
+type matcher struct {
+	queries      []string
+	lowerQueries []string
+	res          []*regexp.Regexp
+	spanRes      []*regexp.Regexp // per-term patterns used to locate highlight spans
+	fieldRes     []*regexp.Regexp // regex terms that define named capture groups
+	isRegex      bool
+	ignoreCase   bool
+	invert       bool
+	or           bool
+}
+
+// newMatcher compiles the query terms of opts. It returns nil when the query is empty.
+func newMatcher(opts SearchOptions) (*matcher, error) {
+	// Parse multiple queries
+	queries := strings.Fields(opts.Query)
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	m := &matcher{
+		queries:    queries,
+		isRegex:    opts.IsRegex,
+		ignoreCase: opts.IgnoreCase,
+		invert:     opts.Invert,
+		or:         opts.Logic == "OR",
+	}
+
+	if opts.IsRegex {
+		for _, q := range queries {
+			pattern := q
+			if opts.IgnoreCase {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex '%s': %v", q, err)
+			}
+			m.res = append(m.res, re)
+			if hasNamedGroups(re) {
+				m.fieldRes = append(m.fieldRes, re)
+			}
+		}
+		m.spanRes = m.res
+	} else {
+		for _, q := range queries {
+			pattern := regexp.QuoteMeta(q)
+			if opts.IgnoreCase {
+				// Pre-lowercase queries for optimization
+				m.lowerQueries = append(m.lowerQueries, strings.ToLower(q))
+				pattern = "(?i)" + pattern
+			}
+			m.spanRes = append(m.spanRes, regexp.MustCompile(pattern))
+		}
+	}
+
+	return m, nil
+}
+
+// termMatch reports whether query term i occurs in the line
+func (m *matcher) termMatch(i int, line []byte, lower string) bool {
+	if m.isRegex {
+		return m.res[i].Match(line)
+	}
+	if m.ignoreCase {
+		return strings.Contains(lower, m.lowerQueries[i])
+	}
+	return strings.Contains(string(line), m.queries[i])
+}
+
+// match applies the AND/OR logic and inversion to a line
+func (m *matcher) match(line []byte) bool {
+	var lower string
+	if !m.isRegex && m.ignoreCase {
+		lower = strings.ToLower(string(line))
+	}
+
+	var matched bool
+	if m.or {
+		// OR logic: any term matches
+		for i := range m.queries {
+			if m.termMatch(i, line, lower) {
+				matched = true
+				break
+			}
+		}
+	} else {
+		// AND logic: all terms must match
+		matched = true
+		for i := range m.queries {
+			if !m.termMatch(i, line, lower) {
+				matched = false
+				break
+			}
+		}
+	}
+
+	if m.invert {
+		matched = !matched
+	}
+	return matched
+}
+
+// findTerms marks the query terms that occur in line in found
+func (m *matcher) findTerms(line []byte, found []bool) {
+	var lower string
+	if !m.isRegex && m.ignoreCase {
+		lower = strings.ToLower(string(line))
+	}
+	for i := range m.queries {
+		if !found[i] && m.termMatch(i, line, lower) {
+			found[i] = true
+		}
+	}
+}
+
+// matchCut is match for a line that was cut short: rest holds the terms
+// found in the part that was cut off
+func (m *matcher) matchCut(line []byte, rest []bool) bool {
+	found := append([]bool(nil), rest...)
+	m.findTerms(line, found)
+
+	matched := !m.or
+	for _, ok := range found {
+		if ok == m.or {
+			matched = m.or
+			break
+		}
+	}
+	if m.invert {
+		matched = !matched
+	}
+	return matched
+}
+
+// spans locates every term match in line. base is the byte offset and
+// baseRunes the code point offset of line within the final content.
+func (m *matcher) spans(line string, base, baseRunes int) []Span {
+	var spans []Span
+	for term, re := range m.spanRes {
+		for _, loc := range re.FindAllStringIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue // Zero-width matches have nothing to highlight
+			}
+			spans = append(spans, Span{Term: term, Start: loc[0], End: loc[1]})
+		}
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+
+	// Convert byte offsets to rune offsets in a single forward pass
+	pos, runes := 0, 0
+	for i := range spans {
+		runes += utf8.RuneCountInString(line[pos:spans[i].Start])
+		pos = spans[i].Start
+		spans[i].RuneStart = baseRunes + runes
+		spans[i].RuneEnd = spans[i].RuneStart + utf8.RuneCountInString(line[spans[i].Start:spans[i].End])
+		spans[i].Start += base
+		spans[i].End += base
+	}
+	return spans
+}
+
+func hasNamedGroups(re *regexp.Regexp) bool {
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// fields extracts the named capture groups of the regex terms from a matched line
+func (m *matcher) fields(line string) map[string]string {
+	var fields map[string]string
+	for _, re := range m.fieldRes {
+		sub := re.FindStringSubmatch(line)
+		if sub == nil {
+			continue
+		}
+		for i, name := range re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			if fields == nil {
+				fields = make(map[string]string)
+			}
+			fields[name] = sub[i]
+		}
+	}
+	return fields
+}
+
+// FieldNames returns the fields the log format, query and pipeline extract into Match.Fields, in order
+func FieldNames(opts SearchOptions) ([]string, error) {
+	m, err := newMatcher(opts)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := newStageChain(opts.Stages)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []string
+	switch opts.Format {
+	case "":
+	case formats.FormatAuto:
+		all = append(all, formats.StandardFields()...)
+	default:
+		format, err := formats.Get(opts.Format)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, format.Fields...)
+	}
+	if m != nil {
+		for _, re := range m.fieldRes {
+			all = append(all, re.SubexpNames()...)
+		}
+	}
+	if chain != nil {
+		all = append(all, chain.fieldNames()...)
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range all {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
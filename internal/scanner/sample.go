@@ -0,0 +1,291 @@
+package scanner
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Supported values for SearchOptions.Sample
+const (
+	SampleReservoir  = "reservoir"  // Scan everything, keep a uniform sample and an exact count
+	SampleStratified = "stratified" // Read evenly spaced windows and extrapolate the count
+)
+
+const (
+	defaultSampleSize = 1000
+	sampleStrata      = 128 // Windows read by stratified sampling
+)
+
+// stratumWindow is the number of bytes read per window
+var stratumWindow int64 = 1 << 20
+
+// Estimate is the total number of matching lines computed by a sampling search
+type Estimate struct {
+	Method       string  `json:"method"`
+	Total        float64 `json:"total"`
+	Low          float64 `json:"low"` // 95% confidence interval
+	High         float64 `json:"high"`
+	Exact        bool    `json:"exact"`
+	SampledBytes int64   `json:"sampledBytes"`
+	TotalBytes   int64   `json:"totalBytes"`
+
+	variance float64
+}
+
+// add combines the estimate of another file into e. Files are sampled
+// independently, so their variances add up.
+func (e *Estimate) add(o Estimate) {
+	e.Total += o.Total
+	e.variance += o.variance
+	e.SampledBytes += o.SampledBytes
+	e.TotalBytes += o.TotalBytes
+	e.Exact = e.Exact && o.Exact
+	if o.Method != e.Method {
+		e.Method = SampleStratified // Some files were small enough to be counted exactly
+	}
+	margin := 1.96 * math.Sqrt(e.variance)
+	e.Low = math.Max(0, math.Round(e.Total-margin))
+	e.High = math.Round(e.Total + margin)
+}
+
+// reservoir keeps a uniform random sample of the matches of one chunk or window
+type reservoir struct {
+	items []Match // LineNumber is chunk-relative
+	seen  int64   // Matching lines seen
+	lines int64   // Lines scanned
+	bytes int64   // Bytes covered
+}
+
+// slot returns where the next match should be stored in a reservoir of size k, or -1 to skip it
+func (r *reservoir) slot(k int) int {
+	r.seen++
+	if len(r.items) < k {
+		r.items = append(r.items, Match{})
+		return len(r.items) - 1
+	}
+	if j := rand.Int64N(r.seen); j < int64(k) {
+		return int(j)
+	}
+	return -1
+}
+
+// sampling reports whether matching lines are sampled instead of streamed
+func (fs *fileScan) sampling() bool {
+	return fs.opts.Sample != "" && !fs.opts.countMode() && !fs.binary
+}
+
+// sampleSize returns the requested sample size
+func (opts SearchOptions) sampleSize() int {
+	if opts.SampleSize > 0 {
+		return opts.SampleSize
+	}
+	return defaultSampleSize
+}
+
+// sampleRange collects a reservoir of the matches starting in [start, end)
+func (fs *fileScan) sampleRange(ctx context.Context, start, end int64, k int) (*reservoir, error) {
+	r := &reservoir{bytes: end - start}
+	err := fs.eachLine(ctx, start, end, func(lineBytes []byte, parsed map[string]string, rest []bool, offset int64) bool {
+		r.lines++
+		line, fields, ok := fs.accept(lineBytes, parsed, rest)
+		if !ok {
+			return true
+		}
+		if i := r.slot(k); i >= 0 {
+			content, cut := truncateLine(line)
+			r.items[i] = Match{
+				File:       fs.path,
+				LineNumber: int(r.lines),
+				Content:    content,
+				Offset:     offset,
+				Fields:     fields,
+				Truncated:  cut,
+			}
+		}
+		return true
+	})
+	return r, err
+}
+
+// reservoirChunk samples one chunk in reservoir mode
+func (fs *fileScan) reservoirChunk(ctx context.Context, chunkIdx, start, end int64) error {
+	r, err := fs.sampleRange(ctx, start, end, fs.opts.sampleSize())
+	fs.orderMu.Lock()
+	fs.reservoirs[chunkIdx] = r
+	fs.orderMu.Unlock()
+	return err
+}
+
+// emitReservoirs merges the chunk reservoirs into one uniform sample of the
+// whole file: each pick takes a chunk with probability proportional to its
+// remaining unsampled matches, then a random item from that chunk's reservoir.
+func (fs *fileScan) emitReservoirs(chunks int64) {
+	var total int64
+	var lineBase int64
+	remaining := make([]int64, chunks)
+	pools := make([][]Match, chunks)
+	for i := int64(0); i < chunks; i++ {
+		r := fs.reservoirs[i]
+		if r == nil {
+			break
+		}
+		for _, m := range r.items {
+			m.LineNumber += int(lineBase)
+			pools[i] = append(pools[i], m)
+		}
+		remaining[i] = r.seen
+		total += r.seen
+		lineBase += r.lines
+	}
+
+	fs.estimate = Estimate{
+		Method:       SampleReservoir,
+		Total:        float64(total),
+		Low:          float64(total),
+		High:         float64(total),
+		Exact:        true,
+		SampledBytes: fs.size,
+		TotalBytes:   fs.size,
+	}
+
+	k := fs.opts.sampleSize()
+	var picked []Match
+	left := total
+	for len(picked) < k && left > 0 {
+		n := rand.Int64N(left)
+		i := 0
+		for n >= remaining[i] {
+			n -= remaining[i]
+			i++
+		}
+		pool := pools[i]
+		j := rand.IntN(len(pool))
+		picked = append(picked, pool[j])
+		pool[j] = pool[len(pool)-1]
+		pools[i] = pool[:len(pool)-1]
+		remaining[i]--
+		left--
+	}
+
+	fs.emitSample(picked)
+}
+
+// sampleFile reads evenly spaced windows of the file and extrapolates the
+// total match count from their match density. Small files are scanned fully.
+func (ps *ParallelScanner) sampleFile(ctx context.Context, fs *fileScan, report func(int64)) error {
+	// Strata must be larger than a window for the windows to be placed at
+	// random; twice as large also keeps the sampled share of the file small
+	if fs.size <= sampleStrata*stratumWindow*2 || fs.records() {
+		return ps.scanFile(ctx, fs, report)
+	}
+
+	k := fs.opts.sampleSize()
+	perStratum := (k + sampleStrata - 1) / sampleStrata
+	stratumSize := fs.size / sampleStrata
+
+	windows := make([]*reservoir, sampleStrata)
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, ps.workerCount)
+
+	for s := int64(0); s < sampleStrata; s++ {
+		if ctx.Err() != nil || fs.failed() {
+			break
+		}
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(s int64) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			// Place the window at a random position inside its stratum, which is
+			// more than twice the window size as checked above
+			start := s*stratumSize + rand.Int64N(stratumSize-stratumWindow)
+			r, err := fs.sampleRange(ctx, start, start+stratumWindow, perStratum)
+			if err != nil {
+				fs.fail(err)
+				return
+			}
+			windows[s] = r
+			report(stratumSize)
+		}(s)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if fs.failed() {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		return fs.err
+	}
+
+	// Cluster sample of windows: total = size * mean density, with the
+	// variance of the density across windows and a finite population correction
+	var picked []Match
+	var sampled int64
+	rates := make([]float64, 0, sampleStrata)
+	for _, r := range windows {
+		rates = append(rates, float64(r.seen)/float64(r.bytes))
+		sampled += r.bytes
+		for _, m := range r.items {
+			m.LineNumber = 0 // Unknown without scanning everything before the window
+			picked = append(picked, m)
+		}
+	}
+
+	var mean, variance float64
+	for _, rate := range rates {
+		mean += rate
+	}
+	mean /= float64(len(rates))
+	for _, rate := range rates {
+		variance += (rate - mean) * (rate - mean)
+	}
+	variance /= float64(len(rates) - 1)
+
+	size := float64(fs.size)
+	fpc := 1 - float64(sampled)/size
+	total := size * mean
+	totalVar := size * size * fpc * variance / float64(len(rates))
+	margin := 1.96 * math.Sqrt(totalVar)
+
+	fs.estimate = Estimate{
+		Method:       SampleStratified,
+		Total:        math.Round(total),
+		Low:          math.Max(0, math.Round(total-margin)),
+		High:         math.Round(total + margin),
+		SampledBytes: sampled,
+		TotalBytes:   fs.size,
+		variance:     totalVar,
+	}
+
+	if len(picked) > k {
+		rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+		picked = picked[:k]
+	}
+	fs.emitSample(picked)
+	return nil
+}
+
+// emitSample sends sampled matches in file order
+func (fs *fileScan) emitSample(picked []Match) {
+	sort.Slice(picked, func(i, j int) bool { return picked[i].Offset < picked[j].Offset })
+	for _, m := range picked {
+		if atomic.LoadInt64(fs.counter) >= fs.maxResults {
+			return
+		}
+		m.Spans = fs.m.spans(m.Content, 0, 0)
+		if fs.hl != nil {
+			m.Colors = fs.hl.Spans(m.Content)
+		}
+		fs.results <- m
+		atomic.AddInt64(fs.counter, 1)
+	}
+}
@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSampleReservoir(t *testing.T) {
+	lines, errorLines := numberedLines(3000)
+	path := writeLog(t, "app.log", lines)
+
+	for _, chunkSize := range []int64{1 << 10, 1 << 20} {
+		t.Run(fmt.Sprint(chunkSize), func(t *testing.T) {
+			matches, summary := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "ERROR", Sample: SampleReservoir, SampleSize: 50})
+			want := float64(len(errorLines))
+			if e := summary.Estimate; e == nil || !e.Exact || e.Total != want || e.Low != want || e.High != want || e.Method != SampleReservoir {
+				t.Fatalf("got estimate %+v, want exactly %v", e, want)
+			}
+			if len(matches) != 50 {
+				t.Fatalf("got %d sampled matches, want 50", len(matches))
+			}
+			seen := make(map[int]bool)
+			for i, m := range matches {
+				if i > 0 && m.Offset <= matches[i-1].Offset {
+					t.Errorf("match %d is out of file order", i)
+				}
+				if seen[m.LineNumber] || m.LineNumber < 1 || m.Content != lines[m.LineNumber-1] {
+					t.Errorf("match %d: line %d holds %q", i, m.LineNumber, m.Content)
+				}
+				seen[m.LineNumber] = true
+			}
+		})
+	}
+
+	// A sample larger than the matches returns all of them
+	matches, _ := scanAll(t, 1<<10, SearchOptions{FilePath: path, Query: "ERROR", Sample: SampleReservoir, SampleSize: 5000})
+	if len(matches) != len(errorLines) {
+		t.Errorf("got %d matches, want all %d", len(matches), len(errorLines))
+	}
+}
+
+func TestSampleStratified(t *testing.T) {
+	defer func(window int64) { stratumWindow = window }(stratumWindow)
+	stratumWindow = 1 << 10
+
+	// 16-byte lines with an error every fourth line: every window holds the same density
+	var sb strings.Builder
+	const lineCount = 1 << 15
+	for i := 0; i < lineCount; i++ {
+		kind := "info "
+		if i%4 == 0 {
+			kind = "ERROR"
+		}
+		fmt.Fprintf(&sb, "%s line %04d\n", kind, i%10000)
+	}
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if size := int64(sb.Len()); size <= sampleStrata*stratumWindow*2 {
+		t.Fatalf("file of %d bytes is small enough to be scanned fully", size)
+	}
+
+	matches, summary := scanAll(t, 1<<20, SearchOptions{FilePath: path, Query: "ERROR", Sample: SampleStratified, SampleSize: 200})
+	e := summary.Estimate
+	if e == nil || e.Method != SampleStratified || e.Exact {
+		t.Fatalf("got estimate %+v", e)
+	}
+	trueCount := float64(lineCount / 4)
+	if e.Low > trueCount || e.High < trueCount {
+		t.Errorf("true count %v is outside [%v, %v]", trueCount, e.Low, e.High)
+	}
+	if e.SampledBytes != sampleStrata*stratumWindow || e.TotalBytes != int64(sb.Len()) {
+		t.Errorf("sampled %d of %d bytes", e.SampledBytes, e.TotalBytes)
+	}
+
+	if len(matches) != 200 {
+		t.Fatalf("got %d sampled matches, want 200", len(matches))
+	}
+	if !sort.SliceIsSorted(matches, func(i, j int) bool { return matches[i].Offset < matches[j].Offset }) {
+		t.Error("sampled matches are out of file order")
+	}
+	for _, m := range matches {
+		if !strings.HasPrefix(m.Content, "ERROR line ") || m.Offset%64 != 0 || m.LineNumber != 0 {
+			t.Errorf("got line %d at offset %d: %q", m.LineNumber, m.Offset, m.Content)
+		}
+	}
+
+	// Small files are counted exactly
+	_, summary = scanAll(t, 1<<20, SearchOptions{FilePath: writeLog(t, "small.log", []string{"ERROR a", "ok", "ERROR b"}), Query: "ERROR", Sample: SampleStratified})
+	if e := summary.Estimate; e == nil || !e.Exact || e.Total != 2 {
+		t.Errorf("small file: got %+v", e)
+	}
+}
@@ -1,34 +1,95 @@
 package scanner
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"unicode/utf8"
+
+	"logana/internal/formats"
+	"logana/internal/highlight"
+	"logana/internal/pipeline"
 )
 
 // Match represents a single match found in the log file
 type Match struct {
-	LineNumber int    `json:"line"`
-	Content    string `json:"content"`
-	Offset     int64  `json:"offset"`
+	File          string                `json:"file,omitempty"`
+	LineNumber    int                   `json:"line"` // 1-based line of the matched line; only set for ordered searches
+	Content       string                `json:"content"`
+	Offset        int64                 `json:"offset"`
+	ContextBefore int                   `json:"contextBefore,omitempty"` // Context lines preceding the matched line in Content
+	Spans         []Span                `json:"spans,omitempty"`
+	Colors        []highlight.ColorSpan `json:"colors,omitempty"`    // Spans colored by the highlight profile
+	Fields        map[string]string     `json:"fields,omitempty"`    // Named capture groups of regex terms
+	Truncated     bool                  `json:"truncated,omitempty"` // A line was too long and was cut short
+	Binary        bool                  `json:"binary,omitempty"`    // The file is binary; Content is a notice
+	Count         int64                 `json:"count,omitempty"`     // Occurrences collapsed into this result (dedupe mode)
+	LastOffset    int64                 `json:"lastOffset,omitempty"`
+	LastLine      int                   `json:"lastLine,omitempty"`
+}
+
+// appendLine adds a line to the match content together with its term spans.
+// newline separates it from the preceding content.
+func (m *Match) appendLine(mt *matcher, line string, newline bool) {
+	if newline {
+		m.Content += "\n"
+	}
+	line, cut := truncateLine(line)
+	m.Truncated = m.Truncated || cut
+	m.Spans = append(m.Spans, mt.spans(line, len(m.Content), utf8.RuneCountInString(m.Content))...)
+	m.Content += line
 }
 
 // SearchOptions defines the parameters for the search
 type SearchOptions struct {
-	FilePath   string
-	Query      string
-	IsRegex    bool
-	IgnoreCase bool
-	Invert     bool
-	Logic      string // "AND" or "OR"
-	Context    int    // Number of lines of context to include
-	MaxResults int    // Maximum number of results to return
+	FilePath         string
+	Files            []string // Additional files searched alongside FilePath
+	Query            string
+	IsRegex          bool
+	IgnoreCase       bool
+	Invert           bool
+	Logic            string             // "AND" or "OR"
+	Context          int                // Number of lines of context to include
+	MaxResults       int                // Maximum number of results to return; negative means unlimited
+	CountOnly        bool               // grep -c: only count matching lines per file
+	FilesWithMatches bool               // grep -l: only report which files contain a match
+	Encoding         string             // "auto" (default), "utf-8", "utf-16le", "utf-16be", "gbk" or "latin1"
+	BinaryAsText     bool               // grep -a: search binary files as if they were text
+	Ordered          bool               // Deliver results in file order with line numbers filled in
+	Stages           []pipeline.Stage   // Filter/transform/extract stages applied after the query
+	Highlight        *highlight.Profile // Coloring rules evaluated on each result
+	Dedupe           string             // "exact" or "masked" collapses repeated lines; results arrive after the scan
+	Sample           string             // "reservoir" or "stratified" returns a random sample of the matches and an estimated total
+	SampleSize       int                // Matches kept when sampling; 0 means 1000
+	Format           string             // "auto" or a log format name adds the parsed line to Fields; empty leaves lines unparsed
+	MinLevel         string             // Only lines at this level or more severe, e.g. "warn"
+	Levels           []string           // Only lines at one of these levels; "unknown" selects lines without a level
+}
+
+// FileCount holds the per-file outcome of a count-only or files-with-matches search
+type FileCount struct {
+	File    string `json:"file"`
+	Count   int64  `json:"count"`
+	Matched bool   `json:"matched"`
+	Binary  bool   `json:"binary,omitempty"`
+}
+
+// Summary describes the aggregate outcome of a search operation
+type Summary struct {
+	Files     []FileCount       `json:"files,omitempty"`
+	Encodings map[string]string `json:"encodings,omitempty"` // Encoding used per file
+	Formats   map[string]string `json:"formats,omitempty"`   // Log format used per file, when one was requested or detected
+	Levels    map[string]int64  `json:"levels,omitempty"`    // Lines matching the query per level, before the level filter
+	Estimate  *Estimate         `json:"estimate,omitempty"`  // Total matching lines (sampling mode)
+	// Lines longer than 10MB. The query is matched against all of such a line,
+	// but fields, levels and pipeline stages only see its first 10MB.
+	TruncatedLines int64 `json:"truncatedLines,omitempty"`
 }
 
 // Result represents the outcome of a search operation
@@ -54,60 +115,293 @@ func NewParallelScanner(workers int) *ParallelScanner {
 	}
 }
 
+// searchPaths returns FilePath followed by Files, skipping empties and duplicates
+func (opts SearchOptions) searchPaths() []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, p := range append([]string{opts.FilePath}, opts.Files...) {
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// countMode reports whether the search only produces per-file counts
+func (opts SearchOptions) countMode() bool {
+	return opts.CountOnly || opts.FilesWithMatches
+}
+
+// fileScan holds the shared state of one file being scanned by several chunk workers
+type fileScan struct {
+	file       *os.File
+	path       string
+	size       int64
+	enc        *textEncoding
+	format     *formats.Format // Parses matched lines into fields; nil if unknown
+	binary     bool            // Report a single notice instead of streaming matched lines
+	opts       SearchOptions
+	m          *matcher
+	chain      *stageChain
+	hl         *highlight.Highlighter
+	levels     *levelFilter
+	mask       *masker // Set in masked dedupe mode
+	results    chan<- Match
+	visitors   chan VisitFunc // Set by Visit: a pool of per-worker visitors
+	counter    *int64         // results emitted across all files
+	maxResults int64
+	count      int64 // matching lines in this file (count modes)
+	found      int32 // set on the first hit (files-with-matches and binary files)
+	truncated  int64 // lines longer than maxLineSize
+	mu         sync.Mutex
+	err        error // first chunk error
+
+	// Ordered delivery: finished chunks wait in pending until all earlier chunks are released
+	orderMu  sync.Mutex
+	pending  map[int64]chunkResult
+	next     int64
+	lineBase int64
+
+	// Dedupe mode: finished chunks wait in chunkGroups until all earlier chunks are merged
+	chunkGroups map[int64]*chunkGroups
+	groups      map[string]*lineGroup
+	groupOrder  []string
+
+	reservoirs map[int64]*reservoir // Sampling mode: sampled matches per chunk
+	estimate   Estimate
+}
+
+// chunkResult holds the buffered output of one chunk in ordered mode
+type chunkResult struct {
+	matches []Match
+	lines   int64
+}
+
+// VisitFunc receives one matching line of a file. Calls to the same VisitFunc
+// never run concurrently.
+type VisitFunc func(file, line string, fields map[string]string)
+
 // Scan performs a parallel search on the file
-func (ps *ParallelScanner) Scan(ctx context.Context, opts SearchOptions, progress chan<- float64, results chan<- Match) error {
-	file, err := os.Open(opts.FilePath)
-	if err != nil {
-		return err
+func (ps *ParallelScanner) Scan(ctx context.Context, opts SearchOptions, progress chan<- float64, results chan<- Match) (Summary, error) {
+	return ps.scan(ctx, opts, progress, results, nil)
+}
+
+// Visit runs the query and pipeline stages of opts over the files in parallel
+// chunks and calls a visitor for every matching line instead of building
+// results. An empty query visits every line. newVisitor is called once per
+// worker, so visitors can keep unsynchronized state that the caller merges
+// after Visit returns. Binary files are skipped unless BinaryAsText is set.
+func (ps *ParallelScanner) Visit(ctx context.Context, opts SearchOptions, progress chan<- float64, newVisitor func() VisitFunc) error {
+	opts.CountOnly, opts.FilesWithMatches, opts.Ordered = false, false, false
+	opts.Dedupe, opts.Sample = "", ""
+
+	visitors := make(chan VisitFunc, ps.workerCount)
+	for i := 0; i < ps.workerCount; i++ {
+		visitors <- newVisitor()
 	}
-	defer file.Close()
+	_, err := ps.scan(ctx, opts, progress, nil, visitors)
+	return err
+}
+
+func (ps *ParallelScanner) scan(ctx context.Context, opts SearchOptions, progress chan<- float64, results chan<- Match, visitors chan VisitFunc) (Summary, error) {
+	var summary Summary
 
-	stat, err := file.Stat()
+	m, err := newMatcher(opts)
+	if err != nil {
+		return summary, err
+	}
+	chain, err := newStageChain(opts.Stages)
+	if err != nil {
+		return summary, err
+	}
+	hl, err := highlight.Compile(opts.Highlight)
+	if err != nil {
+		return summary, err
+	}
+	var mask *masker
+	switch opts.Dedupe {
+	case "", DedupeExact:
+	case DedupeMasked:
+		if mask, err = newMasker(); err != nil {
+			return summary, err
+		}
+	default:
+		return summary, fmt.Errorf("unsupported dedupe mode '%s'", opts.Dedupe)
+	}
+	switch opts.Sample {
+	case "", SampleReservoir, SampleStratified:
+	default:
+		return summary, fmt.Errorf("unsupported sample mode '%s'", opts.Sample)
+	}
+	if opts.Sample != "" && opts.Dedupe != "" {
+		return summary, fmt.Errorf("sampling cannot be combined with dedupe")
+	}
+	levels, err := newLevelFilter(opts)
 	if err != nil {
-		return err
+		return summary, err
+	}
+	if m == nil {
+		if chain == nil && visitors == nil && !levels.active() {
+			return summary, nil
+		}
+		m = &matcher{} // No query terms: the pipeline or level filter alone decides
 	}
-	fileSize := stat.Size()
 
-	// Parse multiple queries
-	queries := strings.Fields(opts.Query)
-	if len(queries) == 0 {
-		return nil
+	paths := opts.searchPaths()
+	files := make([]*os.File, 0, len(paths))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var totalSize int64
+	sizes := make([]int64, 0, len(paths))
+	encs := make([]*textEncoding, 0, len(paths))
+	fmts := make([]*formats.Format, 0, len(paths))
+	binaries := make([]bool, 0, len(paths))
+	for _, p := range paths {
+		file, err := os.Open(p)
+		if err != nil {
+			return summary, err
+		}
+		files = append(files, file)
+
+		stat, err := file.Stat()
+		if err != nil {
+			return summary, err
+		}
+		sizes = append(sizes, stat.Size())
+		totalSize += stat.Size()
+
+		sample, err := readSample(file)
+		if err != nil {
+			return summary, err
+		}
+		enc, err := resolveEncoding(sample, opts.Encoding)
+		if err != nil {
+			return summary, err
+		}
+		encs = append(encs, enc)
+		format, err := resolveFormat(sample, enc, opts.Format)
+		if err != nil {
+			return summary, err
+		}
+		fmts = append(fmts, format)
+		// Binary journal fields hold raw bytes, but the records around them are text
+		binaries = append(binaries, !opts.BinaryAsText && looksBinary(sample, enc) && (format == nil || !format.Records))
+	}
+
+	var totalMatches int64
+	maxResults := int64(opts.MaxResults)
+	if maxResults == 0 {
+		maxResults = 100000
+	} else if maxResults < 0 {
+		maxResults = math.MaxInt64
 	}
 
-	var res []*regexp.Regexp
-	if opts.IsRegex {
-		for _, q := range queries {
-			pattern := q
-			if opts.IgnoreCase {
-				pattern = "(?i)" + pattern
+	var scanned int64
+	report := func(n int64) {
+		done := atomic.AddInt64(&scanned, n)
+		if progress != nil && totalSize > 0 {
+			progress <- float64(done) / float64(totalSize) * 100
+		}
+	}
+
+	summary.Encodings = make(map[string]string, len(files))
+	for i, file := range files {
+		summary.Encodings[paths[i]] = encs[i].name
+		if fmts[i] != nil {
+			if summary.Formats == nil {
+				summary.Formats = make(map[string]string)
 			}
-			re, err := regexp.Compile(pattern)
-			if err != nil {
-				return fmt.Errorf("invalid regex '%s': %v", q, err)
+			summary.Formats[paths[i]] = fmts[i].Name
+		}
+		if visitors != nil && binaries[i] {
+			report(sizes[i])
+			continue
+		}
+		fs := &fileScan{
+			file:        file,
+			path:        paths[i],
+			size:        sizes[i],
+			enc:         encs[i],
+			format:      fmts[i],
+			binary:      binaries[i],
+			opts:        opts,
+			m:           m,
+			chain:       chain,
+			hl:          hl,
+			levels:      levels,
+			mask:        mask,
+			results:     results,
+			visitors:    visitors,
+			counter:     &totalMatches,
+			maxResults:  maxResults,
+			pending:     make(map[int64]chunkResult),
+			chunkGroups: make(map[int64]*chunkGroups),
+			groups:      make(map[string]*lineGroup),
+			reservoirs:  make(map[int64]*reservoir),
+		}
+		scan := ps.scanFile
+		if fs.sampling() && opts.Sample == SampleStratified {
+			scan = ps.sampleFile
+		}
+		if err := scan(ctx, fs, report); err != nil {
+			return summary, err
+		}
+		summary.TruncatedLines += atomic.LoadInt64(&fs.truncated)
+		if fs.sampling() {
+			if summary.Estimate == nil {
+				estimate := fs.estimate
+				summary.Estimate = &estimate
+			} else {
+				summary.Estimate.add(fs.estimate)
+			}
+		}
+		if opts.countMode() {
+			summary.Files = append(summary.Files, FileCount{
+				File:    fs.path,
+				Count:   atomic.LoadInt64(&fs.count),
+				Matched: atomic.LoadInt32(&fs.found) != 0,
+				Binary:  fs.binary,
+			})
+		} else if fs.binary && atomic.LoadInt32(&fs.found) != 0 && atomic.LoadInt64(&totalMatches) < maxResults {
+			results <- Match{
+				File:    fs.path,
+				Content: fmt.Sprintf("Binary file %s matches", fs.path),
+				Binary:  true,
 			}
-			res = append(res, re)
+			atomic.AddInt64(&totalMatches, 1)
 		}
 	}
 
-	chunks := (fileSize + ps.chunkSize - 1) / ps.chunkSize
+	summary.Levels = levels.summary()
+	return summary, nil
+}
+
+// scanFile splits one file into chunks and processes them in parallel
+func (ps *ParallelScanner) scanFile(ctx context.Context, fs *fileScan, report func(int64)) error {
+	chunkSize := ps.chunkSize
+	if fs.records() {
+		chunkSize = max(fs.size, 1) // Records cannot be found from an arbitrary offset
+	}
+	chunks := (fs.size + chunkSize - 1) / chunkSize
 	var wg sync.WaitGroup
 
 	semaphore := make(chan struct{}, ps.workerCount)
 
-	var totalMatches int64
-	maxResults := int64(opts.MaxResults)
-	if maxResults <= 0 {
-		maxResults = 100000
-	}
-
 	for i := int64(0); i < chunks; i++ {
 		select {
 		case <-ctx.Done():
+			wg.Wait()
 			return ctx.Err()
 		default:
 		}
 
-		if atomic.LoadInt64(&totalMatches) >= maxResults {
+		if fs.done() || fs.failed() {
 			break
 		}
 
@@ -118,40 +412,130 @@ func (ps *ParallelScanner) Scan(ctx context.Context, opts SearchOptions, progres
 			defer wg.Done()
 			defer func() { <-semaphore }()
 
-			start := chunkIdx * ps.chunkSize
-			end := start + ps.chunkSize
-			if end > fileSize {
-				end = fileSize
+			start := chunkIdx * chunkSize
+			end := start + chunkSize
+			if end > fs.size {
+				end = fs.size
 			}
 
-			ps.processChunk(file, start, end, opts, queries, res, results, &totalMatches, maxResults)
-
-			if progress != nil {
-				progress <- float64(chunkIdx+1) / float64(chunks) * 100
+			var err error
+			if fs.visitors != nil {
+				err = fs.visitChunk(ctx, start, end)
+			} else if fs.opts.countMode() || fs.binary {
+				err = fs.countChunk(ctx, start, end)
+			} else if fs.dedupe() {
+				err = fs.dedupeChunk(ctx, chunkIdx, start, end)
+			} else if fs.sampling() {
+				err = fs.reservoirChunk(ctx, chunkIdx, start, end)
+			} else {
+				err = fs.processChunk(ctx, chunkIdx, start, end)
+			}
+			if err != nil {
+				fs.fail(err)
 			}
+
+			report(end - start)
 		}(i)
 	}
 
 	wg.Wait()
+	if fs.failed() {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		return fs.err
+	}
+	if fs.dedupe() {
+		fs.emitGroups()
+	} else if fs.sampling() {
+		fs.emitReservoirs(chunks)
+	}
 	return nil
 }
 
-func (ps *ParallelScanner) processChunk(file *os.File, start, end int64, opts SearchOptions, queries []string, res []*regexp.Regexp, results chan<- Match, counter *int64, maxResults int64) {
+// records reports whether the file holds multi-line records, which are read
+// whole by a single worker
+func (fs *fileScan) records() bool {
+	return fs.format != nil && fs.format.Records
+}
+
+// dedupe reports whether matching lines are collapsed instead of streamed
+func (fs *fileScan) dedupe() bool {
+	return fs.opts.Dedupe != "" && !fs.opts.countMode() && !fs.binary
+}
+
+// fail records the first error reported by any chunk of the file
+func (fs *fileScan) fail(err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.err != nil {
+		return
+	}
+	if err != context.Canceled && err != context.DeadlineExceeded {
+		err = fmt.Errorf("reading %s: %w", fs.path, err)
+	}
+	fs.err = err
+}
+
+// failed reports whether a chunk has already failed
+func (fs *fileScan) failed() bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.err != nil
+}
+
+// stopOnFirst reports whether the first hit in the file is all that matters
+func (fs *fileScan) stopOnFirst() bool {
+	return fs.opts.FilesWithMatches || (fs.binary && !fs.opts.CountOnly)
+}
+
+// done reports whether no further chunks of this file need to be scanned
+func (fs *fileScan) done() bool {
+	if fs.stopOnFirst() {
+		return atomic.LoadInt32(&fs.found) != 0
+	}
+	if fs.opts.CountOnly || fs.dedupe() || fs.sampling() || fs.visitors != nil {
+		return false
+	}
+	return atomic.LoadInt64(fs.counter) >= fs.maxResults
+}
+
+// eachLine calls fn for every line starting within [start, end) of the file.
+// Lines crossing end are read to completion; fn returns false to stop early.
+// Lines are decoded to UTF-8 while offsets stay raw file offsets. parsed is
+// set for records, see eachRecord, and nil for lines. A line longer than
+// maxLineSize is cut; rest then holds the query terms found in the part that
+// was cut off, and is nil otherwise.
+func (fs *fileScan) eachLine(ctx context.Context, start, end int64, fn func(line []byte, parsed map[string]string, rest []bool, offset int64) bool) error {
+	if fs.records() {
+		return fs.eachRecord(ctx, start, end, fn)
+	}
+
+	enc := fs.enc
+	nl := enc.newline
+	// Lines start on a code unit boundary, so an unaligned start, such as a
+	// random sampling window in a UTF-16 file, moves to the next one
+	if rem := (start - int64(enc.bomLen)) % int64(enc.unit); start > int64(enc.bomLen) && rem != 0 {
+		start += int64(enc.unit) - rem
+	}
 	currentOffset := start
-	if start > 0 {
-		b := make([]byte, 1)
-		file.ReadAt(b, start-1)
-		if b[0] != '\n' {
+	if start < int64(enc.bomLen) {
+		currentOffset = int64(enc.bomLen)
+	} else if start > 0 {
+		b := make([]byte, len(nl))
+		if _, err := fs.file.ReadAt(b, start-int64(len(nl))); err != nil {
+			return err
+		}
+		if !bytes.Equal(b, nl) {
 			buf := make([]byte, 4096)
 			for {
-				n, err := file.ReadAt(buf, currentOffset)
+				n, err := fs.file.ReadAt(buf, currentOffset)
 				if err != nil && err != io.EOF {
-					return
+					return err
 				}
 				found := false
-				for i := 0; i < n; i++ {
-					if buf[i] == '\n' {
-						currentOffset += int64(i) + 1
+				for i := 0; i+len(nl) <= n; i += enc.unit {
+					if bytes.Equal(buf[i:i+len(nl)], nl) {
+						currentOffset += int64(i + len(nl))
 						found = true
 						break
 					}
@@ -159,137 +543,275 @@ func (ps *ParallelScanner) processChunk(file *os.File, start, end int64, opts Se
 				if found || err == io.EOF || currentOffset >= end {
 					break
 				}
-				currentOffset += int64(n)
+				currentOffset += int64(n - n%enc.unit)
 			}
 		}
 	}
 
 	if currentOffset >= end {
-		return
+		return nil
 	}
 
-	sectionReader := io.NewSectionReader(file, currentOffset, end-currentOffset)
-	scanner := bufio.NewScanner(sectionReader)
-	buf := make([]byte, 0, 1024*1024)
-	scanner.Buffer(buf, 10*1024*1024)
+	sectionReader := io.NewSectionReader(fs.file, currentOffset, fs.size-currentOffset)
+	reader := newLineReader(sectionReader, enc)
+	dec := enc.newLineDecoder()
+	found := make([]bool, len(fs.m.queries))
+	reader.rest = func(piece []byte) { fs.m.findTerms(dec.decode(piece), found) }
 
-	var beforeLines []string
-	afterCount := 0
-	var currentMatch *Match
+	for lines := 0; currentOffset < end; lines++ {
+		if lines&4095 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		raw, size, truncated, ok := reader.next()
+		if !ok {
+			break
+		}
+		var rest []bool
+		if truncated {
+			rest = found
+			atomic.AddInt64(&fs.truncated, 1)
+		}
+		if !fn(dec.decode(enc.trimLine(raw)), nil, rest, currentOffset) {
+			return nil
+		}
+		if truncated {
+			clear(found)
+		}
+		currentOffset += size
+	}
+	return reader.Err()
+}
 
-	// Pre-lowercase queries for optimization
-	var lowerQueries []string
-	if !opts.IsRegex && opts.IgnoreCase {
-		for _, q := range queries {
-			lowerQueries = append(lowerQueries, strings.ToLower(q))
+// eachRecord is eachLine for journal export files: fn gets each record
+// starting within [start, end) rendered on one line, with its parsed fields.
+// Line numbers therefore count records. A record at the end of the file
+// without its closing blank line is passed on with the fields read so far,
+// unless it stops inside a binary field: that one is left out.
+func (fs *fileScan) eachRecord(ctx context.Context, start, end int64, fn func(line []byte, parsed map[string]string, rest []bool, offset int64) bool) error {
+	reader := formats.NewJournalReader(io.NewSectionReader(fs.file, start, fs.size-start), start)
+	for records := 0; ; records++ {
+		if records&4095 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		entry, err := reader.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.Offset >= end {
+			return nil
+		}
+		line, parsed := entry.Parse()
+		if !fn([]byte(line), parsed, nil, entry.Offset) {
+			return nil
 		}
 	}
+}
 
-	for scanner.Scan() {
-		if atomic.LoadInt64(counter) >= maxResults {
-			return
+// countChunk counts matching lines without building any Match content
+func (fs *fileScan) countChunk(ctx context.Context, start, end int64) error {
+	var count, lines int64
+	err := fs.eachLine(ctx, start, end, func(line []byte, parsed map[string]string, rest []bool, offset int64) bool {
+		lines++
+		if fs.stopOnFirst() && lines&4095 == 0 && fs.done() {
+			return false // Another chunk already found a hit
+		}
+		if _, _, ok := fs.accept(line, parsed, rest); !ok {
+			return true
+		}
+		count++
+		if fs.stopOnFirst() {
+			atomic.StoreInt32(&fs.found, 1)
+			return false
+		}
+		return true
+	})
+	if count > 0 {
+		atomic.StoreInt32(&fs.found, 1)
+		if !fs.stopOnFirst() {
+			atomic.AddInt64(&fs.count, count)
 		}
+	}
+	return err
+}
 
-		lineBytes := scanner.Bytes()
-		matched := false
+// visitChunk passes the matching lines of a chunk to a visitor taken from the pool
+func (fs *fileScan) visitChunk(ctx context.Context, start, end int64) error {
+	visit := <-fs.visitors
+	defer func() { fs.visitors <- visit }()
 
-		if opts.Logic == "OR" {
-			// OR logic: any term matches
-			for i, q := range queries {
-				if opts.IsRegex {
-					if res[i].Match(lineBytes) {
-						matched = true
-						break
-					}
-				} else {
-					if opts.IgnoreCase {
-						if strings.Contains(strings.ToLower(string(lineBytes)), lowerQueries[i]) {
-							matched = true
-							break
-						}
-					} else {
-						if strings.Contains(string(lineBytes), q) {
-							matched = true
-							break
-						}
-					}
-				}
-			}
-		} else {
-			// AND logic: all terms must match
-			matched = true
-			for i, q := range queries {
-				termMatched := false
-				if opts.IsRegex {
-					if res[i].Match(lineBytes) {
-						termMatched = true
-					}
-				} else {
-					if opts.IgnoreCase {
-						if strings.Contains(strings.ToLower(string(lineBytes)), lowerQueries[i]) {
-							termMatched = true
-						}
-					} else {
-						if strings.Contains(string(lineBytes), q) {
-							termMatched = true
-						}
-					}
-				}
-				if !termMatched {
-					matched = false
-					break
-				}
+	return fs.eachLine(ctx, start, end, func(lineBytes []byte, parsed map[string]string, rest []bool, offset int64) bool {
+		if line, fields, ok := fs.accept(lineBytes, parsed, rest); ok {
+			visit(fs.path, line, fields)
+		}
+		return true
+	})
+}
+
+// release delivers buffered chunk results in file order, up to maxResults,
+// turning chunk-relative line numbers into absolute ones. A chunk that stopped
+// early has too few lines, but it also filled the results, so no later match
+// is numbered from it.
+func (fs *fileScan) release(chunkIdx int64, res chunkResult) {
+	fs.orderMu.Lock()
+	defer fs.orderMu.Unlock()
+
+	fs.pending[chunkIdx] = res
+	for {
+		r, ok := fs.pending[fs.next]
+		if !ok {
+			return
+		}
+		delete(fs.pending, fs.next)
+		for _, m := range r.matches {
+			if atomic.LoadInt64(fs.counter) >= fs.maxResults {
+				break
 			}
+			m.LineNumber += int(fs.lineBase)
+			fs.results <- m
+			atomic.AddInt64(fs.counter, 1)
+		}
+		fs.lineBase += r.lines
+		fs.next++
+	}
+}
+
+// accept applies the query and the pipeline stages to a line. It returns the
+// line to show, the extracted fields and whether the line is a match. parsed
+// holds the fields of a record; lines are parsed here. rest is set for a
+// line that was cut short, see eachLine.
+func (fs *fileScan) accept(lineBytes []byte, parsed map[string]string, rest []bool) (string, map[string]string, bool) {
+	if rest != nil {
+		if !fs.m.matchCut(lineBytes, rest) {
+			return "", nil, false
 		}
+	} else if !fs.m.match(lineBytes) {
+		return "", nil, false
+	}
+	line := string(lineBytes)
+	if parsed == nil && fs.format != nil {
+		parsed, _ = fs.format.Parse(line)
+	}
+	if !fs.levels.accept(line, parsed[formats.FieldLevel]) {
+		return "", nil, false
+	}
 
-		if opts.Invert {
-			matched = !matched
+	fields := fs.m.fields(line)
+	if parsed != nil {
+		// Named groups of the query take precedence over parsed fields
+		for k, v := range fields {
+			parsed[k] = v
 		}
+		fields = parsed
+	}
+	if fs.chain == nil {
+		return line, fields, true
+	}
 
-		if matched {
-			line := string(lineBytes)
-			if currentMatch != nil {
-				results <- *currentMatch
-				atomic.AddInt64(counter, 1)
-			}
+	line, extracted, ok := fs.chain.run(line)
+	if !ok {
+		return "", nil, false
+	}
+	if fields == nil {
+		return line, extracted, true
+	}
+	for k, v := range extracted {
+		fields[k] = v
+	}
+	return line, fields, true
+}
 
-			var content strings.Builder
-			for _, b := range beforeLines {
-				content.WriteString(b)
-				content.WriteByte('\n')
+// display returns a context line as shown to the user, with transforms applied
+func (fs *fileScan) display(lineBytes []byte) string {
+	if fs.chain == nil {
+		return string(lineBytes)
+	}
+	return fs.chain.transform(string(lineBytes))
+}
+
+func (fs *fileScan) processChunk(ctx context.Context, chunkIdx, start, end int64) error {
+	opts := fs.opts
+
+	var beforeLines []string
+	afterCount := 0
+	var currentMatch *Match
+
+	var lines int64
+	var buffered []Match
+	// Ordered chunks only count their own matches: the shared counter grows as
+	// chunks are released, so a later chunk cannot use up an earlier one's share
+	full := func() bool {
+		if opts.Ordered && int64(len(buffered)) >= fs.maxResults {
+			return true
+		}
+		return atomic.LoadInt64(fs.counter) >= fs.maxResults
+	}
+	emit := func(m *Match) {
+		if fs.hl != nil {
+			m.Colors = fs.hl.Spans(m.Content)
+		}
+		if opts.Ordered {
+			buffered = append(buffered, *m)
+			return
+		}
+		fs.results <- *m
+		atomic.AddInt64(fs.counter, 1)
+	}
+
+	err := fs.eachLine(ctx, start, end, func(lineBytes []byte, parsed map[string]string, rest []bool, currentOffset int64) bool {
+		if full() {
+			currentMatch = nil
+			return false
+		}
+		lines++
+
+		if line, fields, ok := fs.accept(lineBytes, parsed, rest); ok {
+			if currentMatch != nil {
+				emit(currentMatch)
 			}
-			content.WriteString(line)
 
 			currentMatch = &Match{
-				Content: content.String(),
-				Offset:  currentOffset,
+				File:          fs.path,
+				Offset:        currentOffset,
+				ContextBefore: len(beforeLines),
+				Fields:        fields,
+			}
+			if opts.Ordered {
+				currentMatch.LineNumber = int(lines) // Made absolute on release
 			}
+			for i, b := range beforeLines {
+				currentMatch.appendLine(fs.m, b, i > 0)
+			}
+			currentMatch.appendLine(fs.m, line, len(beforeLines) > 0)
 			afterCount = opts.Context
 			beforeLines = nil
 		} else if afterCount > 0 && currentMatch != nil {
-			currentMatch.Content += "\n" + string(lineBytes)
+			currentMatch.appendLine(fs.m, fs.display(lineBytes), true)
 			afterCount--
 			if afterCount == 0 {
-				results <- *currentMatch
-				atomic.AddInt64(counter, 1)
+				emit(currentMatch)
 				currentMatch = nil
 			}
 		} else {
 			if opts.Context > 0 {
-				beforeLines = append(beforeLines, string(lineBytes))
+				beforeLines = append(beforeLines, fs.display(lineBytes))
 				if len(beforeLines) > opts.Context {
 					beforeLines = beforeLines[1:]
 				}
 			}
 		}
+		return true
+	})
 
-		currentOffset += int64(len(lineBytes)) + 1
+	if currentMatch != nil && !full() {
+		emit(currentMatch)
 	}
-
-	if currentMatch != nil && atomic.LoadInt64(counter) < maxResults {
-		results <- *currentMatch
-		atomic.AddInt64(counter, 1)
+	if opts.Ordered {
+		fs.release(chunkIdx, chunkResult{matches: buffered, lines: lines})
 	}
+	return err
 }
 
 func contains(s, substr string, ignoreCase bool) bool {
@@ -0,0 +1,328 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"logana/internal/highlight"
+)
+
+// writeLog writes lines to a file in a temporary directory and returns its path
+func writeLog(t *testing.T, name string, lines []string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// scanAll runs a search with tiny chunks, so lines straddle chunk boundaries
+func scanAll(t *testing.T, chunkSize int64, opts SearchOptions) ([]Match, Summary) {
+	t.Helper()
+	ps := NewParallelScanner(4)
+	ps.chunkSize = chunkSize
+	results := make(chan Match, 1<<16)
+	summary, err := ps.Scan(context.Background(), opts, nil, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(results)
+	var matches []Match
+	for m := range results {
+		matches = append(matches, m)
+	}
+	return matches, summary
+}
+
+// numberedLines returns lines of varying length where every third one is an error
+func numberedLines(n int) (lines []string, errorLines []int) {
+	for i := 1; i <= n; i++ {
+		if i%3 == 0 {
+			lines = append(lines, fmt.Sprintf("ERROR request %d failed%s", i, strings.Repeat("!", i%7)))
+			errorLines = append(errorLines, i)
+		} else {
+			lines = append(lines, fmt.Sprintf("info %d", i))
+		}
+	}
+	return lines, errorLines
+}
+
+func TestScanCountAcrossChunks(t *testing.T) {
+	lines, errorLines := numberedLines(200)
+	path := writeLog(t, "app.log", lines)
+	other := writeLog(t, "other.log", []string{"nothing here", "still nothing"})
+
+	for _, chunkSize := range []int64{1, 7, 16, 64, 1 << 20} {
+		t.Run(fmt.Sprint(chunkSize), func(t *testing.T) {
+			_, summary := scanAll(t, chunkSize, SearchOptions{FilePath: path, Files: []string{other}, Query: "ERROR", CountOnly: true})
+			want := []FileCount{{File: path, Count: int64(len(errorLines)), Matched: true}, {File: other}}
+			if len(summary.Files) != len(want) {
+				t.Fatalf("got %v, want %v", summary.Files, want)
+			}
+			for i, w := range want {
+				if summary.Files[i] != w {
+					t.Errorf("count-only: got %+v, want %+v", summary.Files[i], w)
+				}
+			}
+
+			matches, summary := scanAll(t, chunkSize, SearchOptions{FilePath: path, Files: []string{other}, Query: "ERROR", FilesWithMatches: true})
+			if len(matches) != 0 {
+				t.Errorf("files-with-matches built %d matches", len(matches))
+			}
+			if len(summary.Files) != 2 || !summary.Files[0].Matched || summary.Files[1].Matched {
+				t.Errorf("files-with-matches: got %+v", summary.Files)
+			}
+		})
+	}
+}
+
+func TestScanOrderedLineNumbers(t *testing.T) {
+	lines, errorLines := numberedLines(300)
+	path := writeLog(t, "app.log", lines)
+
+	offsets := make([]int64, len(lines)+1)
+	for i, line := range lines[:len(lines)-1] {
+		offsets[i+2] = offsets[i+1] + int64(len(line)) + 1
+	}
+
+	for _, chunkSize := range []int64{1, 5, 13, 100, 1 << 20} {
+		t.Run(fmt.Sprint(chunkSize), func(t *testing.T) {
+			matches, _ := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "ERROR", Ordered: true, MaxResults: -1})
+			if len(matches) != len(errorLines) {
+				t.Fatalf("got %d matches, want %d", len(matches), len(errorLines))
+			}
+			for i, m := range matches {
+				n := errorLines[i]
+				if m.LineNumber != n || m.Offset != offsets[n] || m.Content != lines[n-1] {
+					t.Errorf("match %d: got line %d offset %d %q, want line %d offset %d %q",
+						i, m.LineNumber, m.Offset, m.Content, n, offsets[n], lines[n-1])
+				}
+			}
+		})
+	}
+}
+
+func TestScanOrderedMaxResults(t *testing.T) {
+	lines, errorLines := numberedLines(120)
+	path := writeLog(t, "app.log", lines)
+
+	// Later chunks finishing first must neither take the place of earlier matches nor shift their numbers
+	for _, chunkSize := range []int64{3, 11, 40} {
+		t.Run(fmt.Sprint(chunkSize), func(t *testing.T) {
+			matches, _ := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "ERROR", Ordered: true, MaxResults: 5})
+			if len(matches) != 5 {
+				t.Fatalf("got %d matches, want 5", len(matches))
+			}
+			for i, m := range matches {
+				if n := errorLines[i]; m.LineNumber != n || m.Content != lines[n-1] {
+					t.Errorf("match %d: got line %d %q, want line %d %q", i, m.LineNumber, m.Content, n, lines[n-1])
+				}
+			}
+		})
+	}
+}
+
+// spanTexts returns the highlighted text of each span, checking that byte and rune offsets agree
+func spanTexts(t *testing.T, m Match) []string {
+	t.Helper()
+	runes := []rune(m.Content)
+	var texts []string
+	for _, s := range m.Spans {
+		text := m.Content[s.Start:s.End]
+		if byRune := string(runes[s.RuneStart:s.RuneEnd]); byRune != text {
+			t.Errorf("span %+v: bytes give %q, runes give %q", s, text, byRune)
+		}
+		texts = append(texts, fmt.Sprintf("%d:%s", s.Term, text))
+	}
+	return texts
+}
+
+func TestScanSpans(t *testing.T) {
+	lines := []string{
+		"启动 service",
+		"用户 alice 请求超时 after 1500ms: Error",
+		"ERROR: 数据库 error again",
+		"done",
+	}
+	path := writeLog(t, "app.log", lines)
+
+	tests := []struct {
+		name      string
+		chunkSize int64
+		opts      SearchOptions
+		want      [][]string // Highlighted "term:text" per match, in content order
+	}{
+		{"ignore case", 1 << 20, SearchOptions{Query: "error", IgnoreCase: true},
+			[][]string{{"0:Error"}, {"0:ERROR", "0:error"}}},
+		{"or with multibyte term", 1 << 20, SearchOptions{Query: "超时 数据库", Logic: "OR"},
+			[][]string{{"0:超时"}, {"1:数据库"}}},
+		{"regex terms", 1 << 20, SearchOptions{Query: `\d+ms (?i)err\w*`, IsRegex: true},
+			[][]string{{"0:1500ms", "1:Error"}}},
+		{"context lines", 1 << 20, SearchOptions{Query: "超时", Context: 1},
+			[][]string{{"0:超时"}}},
+		{"terms in context lines", 1 << 20, SearchOptions{Query: "error 数据库", IgnoreCase: true, Context: 1},
+			[][]string{{"0:Error", "0:ERROR", "1:数据库", "0:error"}}},
+		{"tiny chunks", 3, SearchOptions{Query: "error", IgnoreCase: true},
+			[][]string{{"0:Error"}, {"0:ERROR", "0:error"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.FilePath = path
+			tt.opts.Ordered = true
+			matches, _ := scanAll(t, tt.chunkSize, tt.opts)
+			if len(matches) != len(tt.want) {
+				t.Fatalf("got %d matches, want %d", len(matches), len(tt.want))
+			}
+			for i, m := range matches {
+				got := spanTexts(t, m)
+				if strings.Join(got, "|") != strings.Join(tt.want[i], "|") {
+					t.Errorf("match %d %q: got spans %v, want %v", i, m.Content, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScanLongLines(t *testing.T) {
+	lines := []string{
+		"start",
+		"alpha " + strings.Repeat("x", maxLineSize+1<<20) + " needle",             // Term at the end of an 11MB line
+		strings.Repeat("y", maxLineSize-3) + "needle" + strings.Repeat("y", 1000), // Term across the cut
+		"after needle",
+	}
+	path := writeLog(t, "long.log", lines)
+	lineOffset := int64(len(lines[0]) + len(lines[1]) + len(lines[2]) + 3)
+
+	tests := []struct {
+		name  string
+		opts  SearchOptions
+		lines []int
+	}{
+		{"term in the cut part", SearchOptions{Query: "needle"}, []int{2, 3, 4}},
+		{"terms on both sides of the cut", SearchOptions{Query: "alpha needle"}, []int{2}},
+		{"inverted", SearchOptions{Query: "needle", Invert: true}, []int{1}},
+	}
+	for _, tt := range tests {
+		for _, chunkSize := range []int64{1 << 20, 64 << 20} {
+			t.Run(fmt.Sprint(tt.name, "/", chunkSize), func(t *testing.T) {
+				tt.opts.FilePath, tt.opts.Ordered = path, true
+				matches, summary := scanAll(t, chunkSize, tt.opts)
+				var got []int
+				for _, m := range matches {
+					got = append(got, m.LineNumber)
+					if long := m.LineNumber == 2 || m.LineNumber == 3; m.Truncated != long || len(m.Content) > maxDisplayLine {
+						t.Errorf("line %d: truncated %v with %d bytes of content", m.LineNumber, m.Truncated, len(m.Content))
+					}
+					if m.LineNumber == 4 && m.Offset != lineOffset {
+						t.Errorf("line 4 at offset %d, want %d", m.Offset, lineOffset)
+					}
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tt.lines) {
+					t.Errorf("matched lines %v, want %v", got, tt.lines)
+				}
+				if summary.TruncatedLines != 2 {
+					t.Errorf("summary reports %d truncated lines, want 2", summary.TruncatedLines)
+				}
+			})
+		}
+	}
+
+	_, summary := scanAll(t, 1<<20, SearchOptions{FilePath: path, Query: "needle", CountOnly: true})
+	if len(summary.Files) != 1 || summary.Files[0].Count != 3 {
+		t.Errorf("count-only: got %+v", summary.Files)
+	}
+}
+
+func TestScanBinaryFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.bin")
+	data := "header\x00\x01\x02\nERROR one\nok\nERROR two\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, chunkSize := range []int64{4, 1 << 20} {
+		t.Run(fmt.Sprint(chunkSize), func(t *testing.T) {
+			matches, _ := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "ERROR"})
+			if len(matches) != 1 || !matches[0].Binary || matches[0].Content != "Binary file "+path+" matches" {
+				t.Errorf("got %+v, want one binary notice", matches)
+			}
+
+			matches, _ = scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "missing"})
+			if len(matches) != 0 {
+				t.Errorf("no match: got %+v", matches)
+			}
+
+			_, summary := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "ERROR", CountOnly: true})
+			if want := (FileCount{File: path, Count: 2, Matched: true, Binary: true}); len(summary.Files) != 1 || summary.Files[0] != want {
+				t.Errorf("count-only: got %+v, want %+v", summary.Files, want)
+			}
+
+			matches, _ = scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "ERROR", BinaryAsText: true, Ordered: true})
+			if len(matches) != 2 || matches[0].Content != "ERROR one" || matches[1].LineNumber != 4 || matches[0].Binary {
+				t.Errorf("binary as text: got %+v", matches)
+			}
+		})
+	}
+}
+
+func TestScanJournalExportEnd(t *testing.T) {
+	record := "__REALTIME_TIMESTAMP=1709294400000000\n_HOSTNAME=web1\nSYSLOG_IDENTIFIER=sshd\nMESSAGE=%s\n"
+	first := fmt.Sprintf(record, "login failed") + "\n"
+	tests := []struct {
+		name string
+		last string
+		want int
+	}{
+		{"without closing blank line", fmt.Sprintf(record, "login failed again"), 2},
+		{"cut inside a binary field", "__REALTIME_TIMESTAMP=1709294400000001\nMESSAGE\n\x20\x00\x00\x00\x00\x00\x00\x00login fai", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "system.export")
+			if err := os.WriteFile(path, []byte(first+tt.last), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			matches, summary := scanAll(t, 1<<20, SearchOptions{FilePath: path, Query: "login", Format: "auto", Ordered: true})
+			if summary.Formats[path] != "journald" {
+				t.Fatalf("detected format %q", summary.Formats[path])
+			}
+			if len(matches) != tt.want {
+				t.Fatalf("got %d records, want %d", len(matches), tt.want)
+			}
+			if m := matches[len(matches)-1]; m.LineNumber != tt.want || !strings.Contains(m.Content, "sshd: login failed") {
+				t.Errorf("last record: line %d %q", m.LineNumber, m.Content)
+			}
+		})
+	}
+}
+
+func TestScanHighlight(t *testing.T) {
+	path := writeLog(t, "app.log", []string{"INFO start", "ERROR db 错误", "INFO done"})
+	profile := &highlight.Profile{Rules: []highlight.Rule{
+		{Pattern: "错误", Color: "red", Active: true},
+		{Pattern: "INFO", Color: "gray", WholeLine: true, Active: true},
+	}}
+
+	matches, _ := scanAll(t, 1<<20, SearchOptions{FilePath: path, Query: "ERROR", Context: 1, Highlight: profile})
+	if len(matches) != 1 {
+		t.Fatalf("got %d matches", len(matches))
+	}
+	// Spans cover the context lines too and use offsets into Content
+	var got []string
+	for _, c := range matches[0].Colors {
+		got = append(got, fmt.Sprintf("%s=%s@%d", c.Color, matches[0].Content[c.Start:c.End], c.RuneStart))
+	}
+	if want := "[gray=INFO start@0 red=错误@20 gray=INFO done@23]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	profile.Rules[0].Pattern, profile.Rules[0].IsRegex = "(", true
+	if _, err := NewParallelScanner(1).Scan(context.Background(), SearchOptions{FilePath: path, Query: "ERROR", Highlight: profile}, nil, make(chan Match, 1)); err == nil {
+		t.Error("an invalid highlight rule was accepted")
+	}
+}
@@ -0,0 +1,145 @@
+package scanner
+
+import (
+	"fmt"
+	"regexp"
+
+	"logana/internal/pipeline"
+	"logana/internal/replacer"
+)
+
+// compiledStage is a pipeline stage ready to run on lines
+type compiledStage struct {
+	kind    string
+	m       *matcher
+	rules   *replacer.Compiled
+	extract *regexp.Regexp
+	field   string
+}
+
+// stageChain runs the stages of SearchOptions.Stages over each line in a single pass
+type stageChain struct {
+	stages []compiledStage
+}
+
+// newStageChain compiles the enabled stages. It returns nil when there are none.
+func newStageChain(stages []pipeline.Stage) (*stageChain, error) {
+	chain := &stageChain{}
+	for i, st := range stages {
+		if st.Disabled {
+			continue
+		}
+
+		cs := compiledStage{kind: st.Type}
+		switch st.Type {
+		case pipeline.StageInclude, pipeline.StageExclude:
+			m, err := newMatcher(SearchOptions{
+				Query:      st.Query,
+				IsRegex:    st.IsRegex,
+				IgnoreCase: st.IgnoreCase,
+				Logic:      st.Logic,
+				Invert:     st.Type == pipeline.StageExclude,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("stage %d: %v", i+1, err)
+			}
+			if m == nil {
+				continue // An empty filter keeps everything
+			}
+			cs.m = m
+		case pipeline.StageTransform:
+			rules, err := replacer.Compile(st.Rules)
+			if err != nil {
+				return nil, fmt.Errorf("stage %d: %v", i+1, err)
+			}
+			cs.rules = rules
+		case pipeline.StageExtract:
+			re, err := regexp.Compile(st.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("stage %d: invalid regex '%s': %v", i+1, st.Pattern, err)
+			}
+			if !hasNamedGroups(re) && st.Field == "" {
+				return nil, fmt.Errorf("stage %d: extract pattern needs a named group or a field name", i+1)
+			}
+			cs.extract = re
+			cs.field = st.Field
+		default:
+			return nil, fmt.Errorf("stage %d: unknown stage type '%s'", i+1, st.Type)
+		}
+		chain.stages = append(chain.stages, cs)
+	}
+
+	if len(chain.stages) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// run passes a line through every stage. It returns the transformed line,
+// the extracted fields and whether the line survived all filters.
+func (c *stageChain) run(line string) (string, map[string]string, bool) {
+	var fields map[string]string
+	for _, st := range c.stages {
+		switch st.kind {
+		case pipeline.StageInclude, pipeline.StageExclude:
+			if !st.m.match([]byte(line)) {
+				return "", nil, false
+			}
+		case pipeline.StageTransform:
+			line = st.rules.Replace(line)
+		case pipeline.StageExtract:
+			sub := st.extract.FindStringSubmatch(line)
+			if sub == nil {
+				continue
+			}
+			if fields == nil {
+				fields = make(map[string]string)
+			}
+			if st.field != "" && !hasNamedGroups(st.extract) {
+				// Without named groups the first group, or the whole match, is the value
+				value := sub[0]
+				if len(sub) > 1 {
+					value = sub[1]
+				}
+				fields[st.field] = value
+				continue
+			}
+			for i, name := range st.extract.SubexpNames() {
+				if name != "" {
+					fields[name] = sub[i]
+				}
+			}
+		}
+	}
+	return line, fields, true
+}
+
+// transform applies only the transform stages, for context lines that are shown but not filtered
+func (c *stageChain) transform(line string) string {
+	for _, st := range c.stages {
+		if st.kind == pipeline.StageTransform {
+			line = st.rules.Replace(line)
+		}
+	}
+	return line
+}
+
+// fieldNames lists the fields the extract stages can produce
+func (c *stageChain) fieldNames() []string {
+	var names []string
+	for _, st := range c.stages {
+		if st.kind != pipeline.StageExtract {
+			continue
+		}
+		if st.field != "" && !hasNamedGroups(st.extract) {
+			names = append(names, st.field)
+			continue
+		}
+		for _, name := range st.extract.SubexpNames() {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
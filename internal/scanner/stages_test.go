@@ -0,0 +1,137 @@
+package scanner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"logana/internal/pipeline"
+	"logana/internal/replacer"
+)
+
+var maskNumbers = []replacer.Rule{{Name: "n", Pattern: `\d+`, Replacement: "<N>", Active: true}}
+
+func TestStageChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages []pipeline.Stage
+		line   string
+		want   string // Output line and fields, or "dropped"
+	}{
+		{"include", []pipeline.Stage{{Type: pipeline.StageInclude, Query: "error", IgnoreCase: true}}, "ERROR x", "ERROR x map[]"},
+		{"include drops", []pipeline.Stage{{Type: pipeline.StageInclude, Query: "error"}}, "INFO x", "dropped"},
+		{"exclude", []pipeline.Stage{{Type: pipeline.StageExclude, Query: "health ping", Logic: "OR"}}, "GET /ping", "dropped"},
+		{"exclude keeps", []pipeline.Stage{{Type: pipeline.StageExclude, Query: "health ping", Logic: "OR"}}, "GET /users", "GET /users map[]"},
+		{"transform", []pipeline.Stage{{Type: pipeline.StageTransform, Rules: maskNumbers}}, "id 42", "id <N> map[]"},
+		{"stages run in order", []pipeline.Stage{
+			{Type: pipeline.StageTransform, Rules: maskNumbers},
+			{Type: pipeline.StageInclude, Query: "<N>"},
+		}, "id 42", "id <N> map[]"},
+		{"filters see transformed lines", []pipeline.Stage{
+			{Type: pipeline.StageTransform, Rules: maskNumbers},
+			{Type: pipeline.StageInclude, Query: "42"},
+		}, "id 42", "dropped"},
+		{"extract named groups", []pipeline.Stage{{Type: pipeline.StageExtract, Pattern: `user=(?P<user>\w+) ms=(?P<ms>\d+)`}}, "user=bob ms=12", "user=bob ms=12 map[ms:12 user:bob]"},
+		{"extract into a field", []pipeline.Stage{{Type: pipeline.StageExtract, Pattern: `took (\d+)ms`, Field: "took"}}, "took 15ms", "took 15ms map[took:15]"},
+		{"extract whole match", []pipeline.Stage{{Type: pipeline.StageExtract, Pattern: `\d+ms`, Field: "took"}}, "took 15ms", "took 15ms map[took:15ms]"},
+		{"extract without match", []pipeline.Stage{{Type: pipeline.StageExtract, Pattern: `\d+ms`, Field: "took"}}, "no time", "no time map[]"},
+		{"extract before a transform", []pipeline.Stage{
+			{Type: pipeline.StageExtract, Pattern: `(?P<id>\d+)`},
+			{Type: pipeline.StageTransform, Rules: maskNumbers},
+		}, "id 42", "id <N> map[id:42]"},
+		{"disabled stages skipped", []pipeline.Stage{
+			{Type: pipeline.StageInclude, Query: "nothing", Disabled: true},
+			{Type: pipeline.StageTransform, Rules: maskNumbers},
+		}, "id 42", "id <N> map[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := newStageChain(tt.stages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := "dropped"
+			if line, fields, ok := chain.run(tt.line); ok {
+				got = fmt.Sprint(line, " ", fields)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStageChainCompile(t *testing.T) {
+	for _, stages := range [][]pipeline.Stage{
+		nil,
+		{{Type: pipeline.StageInclude, Query: "x", Disabled: true}},
+		{{Type: pipeline.StageInclude}},
+	} {
+		if chain, err := newStageChain(stages); chain != nil || err != nil {
+			t.Errorf("%+v: got %v, %v; want no chain", stages, chain, err)
+		}
+	}
+
+	tests := []struct {
+		stage pipeline.Stage
+		want  string
+	}{
+		{pipeline.Stage{Type: pipeline.StageInclude, Query: "(", IsRegex: true}, "stage 2:"},
+		{pipeline.Stage{Type: pipeline.StageTransform, Rules: []replacer.Rule{{Name: "bad", Pattern: "(", Active: true}}}, "stage 2:"},
+		{pipeline.Stage{Type: pipeline.StageExtract, Pattern: "("}, "stage 2: invalid regex"},
+		{pipeline.Stage{Type: pipeline.StageExtract, Pattern: `\d+`}, "needs a named group or a field name"},
+		{pipeline.Stage{Type: "sort"}, "unknown stage type 'sort'"},
+	}
+	for _, tt := range tests {
+		_, err := newStageChain([]pipeline.Stage{{Type: pipeline.StageTransform, Rules: maskNumbers}, tt.stage})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: got %v, want %q", tt.stage, err, tt.want)
+		}
+	}
+
+	chain, _ := newStageChain([]pipeline.Stage{
+		{Type: pipeline.StageExtract, Pattern: `(?P<a>\w)(?P<b>\w)`},
+		{Type: pipeline.StageExtract, Pattern: `(\d)`, Field: "c"},
+	})
+	if got := fmt.Sprint(chain.fieldNames()); got != "[a b c]" {
+		t.Errorf("field names: got %s", got)
+	}
+}
+
+func TestScanStages(t *testing.T) {
+	path := writeLog(t, "app.log", []string{
+		"INFO user=ann id 1", "ERROR user=bob id 2", "ERROR user=ann health 3", "INFO id 4", "ERROR user=cid id 5",
+	})
+	stages := []pipeline.Stage{
+		{Type: pipeline.StageExclude, Query: "health"},
+		{Type: pipeline.StageExtract, Pattern: `user=(?P<user>\w+)`},
+		{Type: pipeline.StageTransform, Rules: maskNumbers},
+	}
+
+	for _, chunkSize := range []int64{8, 1 << 20} {
+		t.Run(fmt.Sprint(chunkSize), func(t *testing.T) {
+			matches, _ := scanAll(t, chunkSize, SearchOptions{FilePath: path, Query: "ERROR", Stages: stages, Ordered: true})
+			var got []string
+			for _, m := range matches {
+				got = append(got, fmt.Sprintf("%d %q %v", m.LineNumber, m.Content, m.Fields))
+			}
+			want := []string{`2 "ERROR user=bob id <N>" map[user:bob]`, `5 "ERROR user=cid id <N>" map[user:cid]`}
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+
+	// Context lines are transformed but not filtered
+	matches, _ := scanAll(t, 1<<20, SearchOptions{FilePath: path, Query: "ERROR", Stages: stages, Context: 1})
+	want := []string{"INFO user=ann id <N>\nERROR user=bob id <N>\nERROR user=ann health <N>", "INFO id <N>\nERROR user=cid id <N>"}
+	if len(matches) != len(want) || matches[0].Content != want[0] || matches[1].Content != want[1] {
+		t.Errorf("context: got %+v", matches)
+	}
+
+	// Stages alone select lines without a query
+	matches, _ = scanAll(t, 1<<20, SearchOptions{FilePath: path, Stages: []pipeline.Stage{{Type: pipeline.StageInclude, Query: "id 4"}}})
+	if len(matches) != 1 || matches[0].Content != "INFO id 4" {
+		t.Errorf("stages without query: got %+v", matches)
+	}
+}